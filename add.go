@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// helpers for add values like `connection to {{.host | upper}} failed in {{env "POD" | default "unknown"}}`
+var addFuncs = texttemplate.FuncMap{
+	"env":   os.Getenv,
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"truncate": func(length int, value string) string {
+		if runes := []rune(value); len(runes) > length {
+			return string(runes[:length])
+		}
+		return value
+	},
+	"default": func(fallback string, value string) string {
+		if value == "" {
+			return fallback
+		}
+		return value
+	},
+}
+
+// add value that is rendered from the log, so it can use captures
+type addTemplate struct {
+	key      string
+	template *texttemplate.Template
+}
+
+// split add into values that can be merged as they are and templates,
+// sorted so templates are rendered in the same order every time
+func compileAdd(add map[string]string, location string) (static map[string]string, templates []addTemplate, err error) {
+	static = map[string]string{}
+	for key, value := range add {
+		if !strings.Contains(value, "{{") {
+			static[key] = value
+			continue
+		}
+		parsed, err := texttemplate.New(key).Funcs(addFuncs).Option("missingkey=zero").Parse(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s.%s must be a valid template but was %s (%v)", location, key, value, err)
+		}
+		templates = append(templates, addTemplate{key: key, template: parsed})
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].key < templates[j].key })
+	return static, templates, nil
+}
+
+// render templates with the captures and what was added so far, keeping the old value when rendering fails
+func renderAdd(log *OrderedMap, templates []addTemplate) {
+	for _, t := range templates {
+		var b strings.Builder
+		if err := t.template.Execute(&b, log.values); err == nil {
+			log.Set(t.key, b.String())
+		}
+	}
+}
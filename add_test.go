@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("add", func() {
+	It("renders templates with captures", func() {
+		withConfig("---\npatterns:\n- regex: 'connecting to (?P<host>[^\\s:]+)(?P<port>:\\d+)?'\n  ignoreMetricLabels: [host, port]\n  add:\n    pattern: connection\n    message: 'connection to {{.host}} failed on port {{.port | default \"80\"}}'", func() {
+			Expect(parse("connecting to a.com:443\nconnecting to b.com")).To(Equal(
+				"{\"message\":\"connection to a.com failed on port :443\",\"host\":\"a.com\",\"port\":\":443\",\"pattern\":\"connection\"}\n" +
+					"{\"message\":\"connection to b.com failed on port 80\",\"host\":\"b.com\",\"port\":\"\",\"pattern\":\"connection\"}",
+			))
+		})
+	})
+
+	It("uses rendered values as metric labels", func() {
+		withConfig("---\npatterns:\n- regex: '(?P<host>\\S+) down'\n  ignoreMetricLabels: [host]\n  add: {target: '{{.host | upper | truncate 3}}'}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(prepareLine(StreamLine{line: "abcd down"}, config).labels).To(Equal(map[string]string{"target": "ABC"}))
+			Expect(prepareLine(StreamLine{line: "ab down"}, config).labels).To(Equal(map[string]string{"target": "AB"}))
+		})
+	})
+
+	It("reads the environment", func() {
+		os.Setenv("LOGRECYCLER_POD", "pod-1")
+		defer os.Unsetenv("LOGRECYCLER_POD")
+		withConfig("---\npatterns:\n- regex: hi\n  add: {pod: '{{env \"LOGRECYCLER_POD\" | default \"none\" | lower}}', missing: '{{.nope}}'}", func() {
+			Expect(parse("hi")).To(Equal("{\"message\":\"hi\",\"missing\":\"\",\"pod\":\"pod-1\"}"))
+		})
+	})
+
+	It("keeps the value when rendering fails", func() {
+		withConfig("---\npatterns:\n- regex: hi\n  add: {a: '{{truncate \"x\" .message}}'}", func() {
+			Expect(parse("hi")).To(Equal("{\"message\":\"hi\"}"))
+		})
+	})
+
+	It("fails on invalid templates", func() {
+		withConfig("---\npatterns:\n- regex: hi\n  add: {a: '{{.a'}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("patterns[0].add.a must be a valid template but was {{.a (template: a:1: unclosed action)"))
+		})
+	})
+})
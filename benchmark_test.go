@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// realistic configs with lines they see in production, every op is one line
+var benchmarkCorpora = []struct {
+	name   string
+	config string
+	lines  []string
+}{
+	{
+		name:   "glog",
+		config: "timestampKey: ts\nlevelKey: level\nglog: simple\npatterns:\n- regex: 'request (?P<path>\\S+) took (?P<duration>\\d+)ms'\n  add: {pattern: request}\n  ignoreMetricLabels: [path, duration]\n- regex: ''\n  add: {pattern: unknown}\n",
+		lines: []string{
+			"I0530 10:13:00.740596      33 server.go:132] request /api/v1/users took 12ms",
+			"W0530 10:13:01.002002      33 client.go:57] retrying connection to 10.0.0.1:443",
+			"E0530 10:13:02.123456      33 server.go:99] failed to write response: broken pipe",
+		},
+	},
+	{
+		name:   "json",
+		config: "timestampKey: ts\nlevelKey: level\nmessageKey: msg\njson: simple\nallowMetricLabels: [level, pattern]\npatterns:\n- regex: '^request'\n  add: {pattern: request}\n",
+		lines: []string{
+			`{"level":"info","msg":"request finished","status":200,"path":"/api/v1/users","duration":0.012}`,
+			`{"level":"error","msg":"connection refused","host":"db-1","port":5432}`,
+			`{"level":"debug","msg":"cache \"users\" <hit>","ratio":0.97}`,
+		},
+	},
+	{
+		name:   "plaintext",
+		config: "levelKey: level\npatterns:\n- regex: 'error connecting to remote host (?P<host>\\S+):(?P<port>\\d+)'\n  level: ERROR\n  add: {pattern: connect}\n  ignoreMetricLabels: [port]\n- regex: 'took (?P<duration>\\d+)ms'\n  add: {pattern: timing}\n  ignoreMetricLabels: [duration]\n- regex: ''\n  add: {pattern: unknown}\n",
+		lines: []string{
+			"error connecting to remote host foobar.com:1234",
+			"request took 12ms",
+			"starting worker 3 of 8 with \ttabs and ünïcode",
+		},
+	},
+	{
+		name:   "node-problem-detector",
+		config: "examples/kubernetes_node_problem_detector.yaml",
+		lines: []string{
+			"I0530 10:13:00.740596      33 plugin.go:92] Rule: &{Path:/config/check.sh Args:[] Timeout:5s Condition:KernelDeadlock Reason:DockerHung Pattern:} Duration: 12.5ms",
+			"I0530 10:13:00.740596      33 plugin.go:94] Add check result {Rule:0xc0001 ExitStatus:0 Message:ok} for rule &{Path:/config/check.sh Condition:KernelDeadlock Reason:DockerHung}",
+			"I0530 10:13:00.740596      33 custom_plugin_monitor.go:81] Finish running custom plugins",
+			"I0530 10:13:00.740596      33 log_monitor.go:160] Something we have never seen before",
+		},
+	},
+}
+
+// config content or path to an example config, with extra config appended
+func benchmarkConfig(b *testing.B, content string, extra string) *Config {
+	if filepath.Ext(content) == ".yaml" {
+		example, err := os.ReadFile(content)
+		if err != nil {
+			b.Fatal(err)
+		}
+		content = string(example)
+	}
+	path := filepath.Join(b.TempDir(), "logrecycler.yaml")
+	if err := os.WriteFile(path, []byte(content+"\n"+extra), 0644); err != nil {
+		b.Fatal(err)
+	}
+	config, err := NewConfig(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	config.Statsd = nil // would send udp packets
+	return config
+}
+
+// go test -run none -bench ProcessLine -benchmem
+// cpu and memory overhead per line from reading it to writing it and counting it in metrics
+func BenchmarkProcessLine(b *testing.B) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	for _, corpus := range benchmarkCorpora {
+		b.Run(corpus.name, func(b *testing.B) {
+			config := benchmarkConfig(b, corpus.config, "prometheus:\n  port: "+randomPort())
+			config.Prometheus.Start()
+			defer config.Prometheus.Stop()
+			config.Output.stdout.start(devNull, config.Output.BufferSize)
+			config.Output.stderr.start(devNull, config.Output.BufferSize)
+			defer config.Output.Stop()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				processLine(StreamLine{line: corpus.lines[i%len(corpus.lines)]}, config)
+			}
+		})
+	}
+}
+
+// go test -run none -bench Serialize -benchmem
+func BenchmarkSerialize(b *testing.B) {
+	log := NewOrderedMap()
+	log.Set("ts", "2020-01-02T03:04:05Z")
+	log.Set("level", "INFO")
+	log.Set("message", "request /api/v1/users?name=\"foo\"&bar=<baz> took 12ms")
+	log.Set("pattern", "request")
+	log.Set("path", "/api/v1/users")
+	log.Set("unicode", "ünïcode\t ")
+
+	for _, format := range []string{"json", "logfmt", "console"} {
+		b.Run(format, func(b *testing.B) {
+			output := &Output{Format: format}
+			config := &Config{TimestampKey: "ts", LevelKey: "level", MessageKey: "message"}
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				output.serialize(log, config, nil)
+			}
+		})
+	}
+}
+
+// go test -run none -bench Metrics -benchmem
+func BenchmarkMetrics(b *testing.B) {
+	labels := []string{"level", "pattern", "host"}
+	values := make([]map[string]string, 10)
+	for i := range values {
+		values[i] = map[string]string{"level": "INFO", "pattern": "p" + strconv.Itoa(i), "host": "web-1"}
+	}
+
+	b.Run("prometheus", func(b *testing.B) {
+		p := &Prometheus{Port: randomPort(), Labels: labels}
+		p.Start()
+		defer p.Stop()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			p.Inc(values[i%len(values)])
+		}
+	})
+
+	b.Run("otlp", func(b *testing.B) {
+		o := &Otlp{metrics: true, counts: map[string]*otlpCount{}} // counting without exporting
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			o.Inc(values[i%len(values)])
+		}
+	})
+}
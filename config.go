@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -14,30 +15,72 @@ type Pattern struct {
 	Regex              string
 	regexParsed        *regexp.Regexp
 	Discard            bool
-	Add                map[string]string
+	Add                map[string]string // values can be templates like `{{.host}}`
+	addStatic          map[string]string
+	addTemplates       []addTemplate
 	Level              string
 	levelSet           bool
 	IgnoreMetricLabels []string `yaml:"ignoreMetricLabels"`
 	SampleRate         *float32 `yaml:"sampleRate"`
+	SampleBy           string   `yaml:"sampleBy"`     // keep all or no lines with the same value of this field
+	CountSampled       bool     `yaml:"countSampled"` // count lines that were sampled out in metrics
+	sampleWeight       string
+	RateLimit          *RateLimit        `yaml:"rateLimit"`
+	Convert            map[string]string // field -> int, float, bool, duration, bytes, lowercase, trim or urldecode
+	convert            []conversion
+	FieldOperations    `yaml:",inline"`
+}
+
+// additional sources of log lines, processed like stdin
+type Input struct {
+	File   string // glob of files to follow like `tail -F`
+	Format string // envelope of the lines in the file, defaults to the global format
+	Syslog string // address to receive syslog messages on, for example udp://0.0.0.0:514
 }
 
 type Config struct {
-	Prometheus        *Prometheus
-	Statsd            *Statsd
-	Glog              string
-	glogSet           bool
-	Json              string
-	jsonSet           bool
-	AllowMetricLabels []string `yaml:"allowMetricLabels"`
-	TimestampKey      string   `yaml:"timestampKey"`
-	timestampKeySet   bool
-	LevelKey          string `yaml:"levelKey"`
-	levelKeySet       bool
-	MessageKey        string `yaml:"messageKey"`
-	Patterns          []Pattern
-	Preprocess        string
-	preprocessSet     bool
-	preprocessParsed  *regexp.Regexp
+	Prometheus         *Prometheus
+	Statsd             *Statsd
+	Otlp               *Otlp
+	Glog               string
+	glogSet            bool
+	Json               string
+	jsonSet            bool
+	AllowMetricLabels  []string `yaml:"allowMetricLabels"`
+	TimestampKey       string   `yaml:"timestampKey"`
+	timestampKeySet    bool
+	LevelKey           string `yaml:"levelKey"`
+	levelKeySet        bool
+	LevelMap           map[string]string `yaml:"levelMap"` // spelling -> canonical level, on top of common spellings
+	levelMap           map[string]string // lowercase spelling -> canonical level
+	LevelCase          string            `yaml:"levelCase"` // lower or upper
+	normalizeLevelsSet bool
+	MinLevel           string `yaml:"minLevel"` // count but do not write lines below this level
+	minLevel           int
+	MessageKey         string `yaml:"messageKey"`
+	Patterns           []Pattern
+	Preprocess         string
+	preprocessSet      bool
+	preprocessParsed   *regexp.Regexp
+	Inputs             []Input
+	Positions          string
+	Format             string
+	StreamKey          string `yaml:"streamKey"`
+	streamKeySet       bool
+	StreamLevels       map[string]string `yaml:"streamLevels"`
+	Output             *Output
+	Workers            int
+	patternFilter      *patternFilter
+	SampleKey          string `yaml:"sampleKey"`
+	sampleKeySet       bool
+	SampleSeed         *int64 `yaml:"sampleSeed"`
+	sampler            *sampler
+	Dedupe             *Dedupe
+	Templates          *Templates
+	Enrich             []Enrich
+	Fields             yaml.MapSlice // added to every line after timestamp and level
+	fields             []globalField
+	FieldOperations    `yaml:",inline"` // applied to all lines before patterns
 }
 
 var glogRegex = regexp.MustCompile(`^([IWEF])(\d{2})(\d{2}) (\d{2}):(\d{2}):(\d{2})\.\d+ +\d+ \S+:\d+] `)
@@ -49,6 +92,8 @@ var glogLevels = map[string]string{
 }
 var timeFormat = time.RFC3339
 
+const fileKey = "file"
+
 func NewConfig(path string) (*Config, error) {
 	// read config
 	var config Config
@@ -77,12 +122,106 @@ func NewConfig(path string) (*Config, error) {
 			if rate < 0.0 || rate > 1.0 {
 				return nil, fmt.Errorf("sample must be between 0.0 - 1.0 but was %f", rate)
 			}
+			config.Patterns[i].sampleWeight = sampleWeight(rate)
+		} else if config.Patterns[i].SampleBy != "" {
+			return nil, fmt.Errorf("patterns[%d].sampleBy needs sampleRate to be set", i)
+		} else if config.Patterns[i].CountSampled {
+			return nil, fmt.Errorf("patterns[%d].countSampled needs sampleRate to be set", i)
+		}
+
+		if config.Patterns[i].addStatic, config.Patterns[i].addTemplates, err = compileAdd(config.Patterns[i].Add, "patterns["+strconv.Itoa(i)+"].add"); err != nil {
+			return nil, err
+		}
+
+		config.Patterns[i].FieldOperations.setup()
+
+		if config.Patterns[i].convert, err = buildConversions(config.Patterns[i].Convert, "patterns["+strconv.Itoa(i)+"].convert"); err != nil {
+			return nil, err
+		}
+
+		if config.Patterns[i].RateLimit != nil {
+			if err = config.Patterns[i].RateLimit.setup("patterns[" + strconv.Itoa(i) + "].rateLimit"); err != nil {
+				return nil, err
+			}
 		}
 	}
+	config.FieldOperations.setup()
+	config.sampler = newSampler(config.SampleSeed)
+	config.sampleKeySet = (config.SampleKey != "")
+	config.patternFilter = newPatternFilter(config.Patterns)
 	config.timestampKeySet = (config.TimestampKey != "")
 	config.levelKeySet = (config.LevelKey != "")
+	config.streamKeySet = (config.StreamKey != "")
 	config.glogSet = (config.Glog != "")
 	config.jsonSet = (config.Json != "")
+	if err = config.setupLevels(); err != nil {
+		return nil, err
+	}
+	if err = config.setupFields(); err != nil {
+		return nil, err
+	}
+	if config.Dedupe != nil {
+		if err = config.Dedupe.setup(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range config.Enrich {
+		if err = config.Enrich[i].setup("enrich[" + strconv.Itoa(i) + "]"); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Templates != nil {
+		if err = config.Templates.setup(); err != nil {
+			return nil, err
+		}
+		if config.Prometheus == nil {
+			return nil, fmt.Errorf("templates needs prometheus to be set") // to show them at /debug/templates
+		}
+	}
+
+	// after everything that adds possible labels
+	if config.Prometheus != nil {
+		if err = config.setupConstLabels(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Workers < 0 {
+		return nil, fmt.Errorf("workers must be positive but was %d", config.Workers)
+	}
+
+	for stream := range config.StreamLevels {
+		if err = validateStream(stream); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Output == nil {
+		config.Output = &Output{}
+	}
+	if err = config.Output.setup(&config); err != nil {
+		return nil, err
+	}
+
+	if config.Otlp != nil {
+		if err = config.Otlp.setup(&config); err != nil {
+			return nil, err
+		}
+		if config.Otlp.logs != nil {
+			config.Output.sinks = append(config.Output.sinks, config.Otlp.logs)
+		}
+	}
+
+	if err = validateFormat(config.Format); err != nil {
+		return nil, err
+	}
+	for _, input := range config.Inputs {
+		if err = validateFormat(input.Format); err != nil {
+			return nil, err
+		}
+	}
 
 	// preprocess
 	config.preprocessSet = (config.Preprocess != "")
@@ -90,12 +229,52 @@ func NewConfig(path string) (*Config, error) {
 		config.preprocessParsed = helpfulMustCompile(config.Preprocess, "preprocess")
 	}
 
-	// store all possible labels
-	if config.Prometheus != nil {
-		config.Prometheus.Labels = config.possibleLabels()
+	return &config, nil
+}
+
+// parse a duration like 1s or 5m, using the fallback when not set
+func parseDuration(value string, fallback time.Duration, location string) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
 	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 1s or 5m but was %s", location, value)
+	}
+	return duration, nil
+}
 
-	return &config, nil
+var byteSizeRegex = regexp.MustCompile(`^(\d+) ?([KMG]?B)?$`)
+var byteUnits = map[string]int64{"": 1, "B": 1, "KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30}
+
+// parse a size like 512KB or 100MB, 0 when not set
+func parseBytes(value string, location string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	match := byteSizeRegex.FindStringSubmatch(strings.ToUpper(value))
+	if match == nil {
+		return 0, fmt.Errorf("%s must be a size like 512KB or 100MB but was %s", location, value)
+	}
+	number, err := strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a size like 512KB or 100MB but was %s", location, value)
+	}
+	return number * byteUnits[match[2]], nil
+}
+
+// all file inputs with their format
+func (c *Config) files() []Input {
+	files := []Input{}
+	for _, input := range c.Inputs {
+		if input.File != "" {
+			if input.Format == "" {
+				input.Format = c.Format
+			}
+			files = append(files, input)
+		}
+	}
+	return files
 }
 
 // all labels that could ever be used by the given config
@@ -106,10 +285,27 @@ func (c *Config) possibleLabels() []string {
 		labels = append(labels, c.LevelKey)
 	}
 
+	if c.streamKeySet {
+		labels = append(labels, c.StreamKey)
+	}
+
+	if len(c.files()) != 0 {
+		labels = append(labels, fileKey)
+	}
+
+	for _, input := range c.Inputs {
+		if input.Syslog != "" {
+			labels = append(labels, syslogHostnameKey, syslogAppNameKey)
+			break
+		}
+	}
+
 	if c.preprocessSet {
 		addCaptureNames(c.preprocessParsed, &labels)
 	}
 
+	labels = c.FieldOperations.labels(labels)
+
 	// all possible captures and `add`
 	for _, pattern := range c.Patterns {
 		if pattern.Discard {
@@ -122,6 +318,7 @@ func (c *Config) possibleLabels() []string {
 		if pattern.Add != nil {
 			patternLabels = append(patternLabels, keys(pattern.Add)...)
 		}
+		patternLabels = pattern.FieldOperations.labels(patternLabels)
 
 		for _, l := range pattern.IgnoreMetricLabels {
 			patternLabels = removeElement(patternLabels, l)
@@ -130,6 +327,12 @@ func (c *Config) possibleLabels() []string {
 		labels = append(labels, patternLabels...)
 	}
 
+	for i := range c.Enrich {
+		if c.Enrich[i].MetricLabels {
+			labels = append(labels, c.Enrich[i].labels()...)
+		}
+	}
+
 	labels = unique(labels)
 	labels = removeElement(labels, c.MessageKey) // would make stats useless
 
@@ -40,4 +40,14 @@ var _ = Describe("config", func() {
 			}
 		})
 	})
+
+	Describe("possibleLabels", func() {
+		It("includes the file when following files", func() {
+			withConfig("---\ninputs:\n- file: '*.log'\nlevelKey: level", func() {
+				config, err := NewConfig("logrecycler.yaml")
+				Expect(err).To(BeNil())
+				Expect(config.possibleLabels()).To(Equal([]string{"level", "file"}))
+			})
+		})
+	})
 })
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// converts a value, raw values are written to json as they are (numbers and booleans),
+// values that cannot be converted stay as they were
+type converter func(value string) (converted string, raw bool, ok bool)
+
+var converters = map[string]converter{
+	"int": func(value string) (string, bool, bool) {
+		number, err := strconv.ParseInt(value, 10, 64)
+		return strconv.FormatInt(number, 10), true, err == nil
+	},
+	"float": func(value string) (string, bool, bool) {
+		number, err := strconv.ParseFloat(value, 64)
+		return formatNumber(number), true, err == nil && !math.IsInf(number, 0) && !math.IsNaN(number)
+	},
+	"bool": func(value string) (string, bool, bool) {
+		b, err := strconv.ParseBool(value)
+		return strconv.FormatBool(b), true, err == nil
+	},
+	"duration": func(value string) (string, bool, bool) { // to seconds
+		duration, err := time.ParseDuration(value)
+		return formatNumber(duration.Seconds()), true, err == nil
+	},
+	"bytes": func(value string) (string, bool, bool) {
+		size, err := parseBytes(value, "")
+		return strconv.FormatInt(size, 10), true, err == nil && value != ""
+	},
+	"lowercase": func(value string) (string, bool, bool) {
+		return strings.ToLower(value), false, true
+	},
+	"trim": func(value string) (string, bool, bool) {
+		return strings.TrimSpace(value), false, true
+	},
+	"urldecode": func(value string) (string, bool, bool) {
+		decoded, err := url.QueryUnescape(value)
+		return decoded, false, err == nil
+	},
+}
+
+type conversion struct {
+	key       string
+	converter converter
+}
+
+// what the config asked to convert, sorted so conversions happen in the same order every time
+func buildConversions(convert map[string]string, location string) ([]conversion, error) {
+	keys := make([]string, 0, len(convert))
+	for key := range convert {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	conversions := make([]conversion, len(keys))
+	for i, key := range keys {
+		converter, found := converters[convert[key]]
+		if !found {
+			names := make([]string, 0, len(converters))
+			for name := range converters {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("%s.%s must be one of %s but was %s", location, key, strings.Join(names, ", "), convert[key])
+		}
+		conversions[i] = conversion{key: key, converter: converter}
+	}
+	return conversions, nil
+}
+
+func applyConversions(log *OrderedMap, conversions []conversion) {
+	for _, c := range conversions {
+		if value, found := log.values[c.key]; found {
+			if converted, raw, ok := c.converter(value); ok {
+				log.setValue(c.key, converted, raw)
+			}
+		}
+	}
+}
+
+// shortest representation that is still valid json, so 1.0 becomes 1
+func formatNumber(number float64) string {
+	return strconv.FormatFloat(number, 'g', -1, 64)
+}
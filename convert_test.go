@@ -0,0 +1,80 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("convert", func() {
+	convert := func(name string, value string) (string, bool, bool) {
+		return converters[name](value)
+	}
+
+	It("converts values", func() {
+		for _, example := range []struct {
+			name      string
+			value     string
+			converted string
+			raw       bool
+		}{
+			{"int", "0042", "42", true},
+			{"int", "-1", "-1", true},
+			{"float", "1.50", "1.5", true},
+			{"float", "2", "2", true},
+			{"bool", "TRUE", "true", true},
+			{"bool", "0", "false", true},
+			{"duration", "1m30s", "90", true},
+			{"duration", "12.3ms", "0.0123", true},
+			{"bytes", "2KB", "2048", true},
+			{"lowercase", "GET", "get", false},
+			{"trim", " a b ", "a b", false},
+			{"urldecode", "a%20b+c", "a b c", false},
+		} {
+			converted, raw, ok := convert(example.name, example.value)
+			Expect([]any{example.name, example.value, converted, raw, ok}).To(Equal([]any{example.name, example.value, example.converted, example.raw, true}))
+		}
+	})
+
+	It("does not convert invalid values", func() {
+		for _, example := range [][]string{{"int", "1.5"}, {"float", "NaN"}, {"float", "1e999"}, {"float", "x"}, {"bool", "yes"}, {"duration", "5"}, {"bytes", "1TB"}, {"bytes", ""}, {"urldecode", "%zz"}} {
+			_, _, ok := convert(example[0], example[1])
+			Expect(ok).To(BeFalse(), example[0]+" "+example[1])
+		}
+	})
+
+	It("writes converted captures as json values", func() {
+		withConfig("---\npatterns:\n- regex: '(?P<method>\\S+) (?P<port>\\S+) (?P<took>\\S+) (?P<ok>\\S+)'\n  convert: {method: lowercase, port: int, took: duration, ok: bool}", func() {
+			Expect(parse("GET 80 1.5s true\nGET x y z")).To(Equal(
+				"{\"message\":\"GET 80 1.5s true\",\"method\":\"get\",\"port\":80,\"took\":1.5,\"ok\":true}\n" +
+					"{\"message\":\"GET x y z\",\"method\":\"get\",\"port\":\"x\",\"took\":\"y\",\"ok\":\"z\"}",
+			))
+		})
+	})
+
+	It("uses converted values as metric labels", func() {
+		withConfig("---\npatterns:\n- regex: '(?P<method>\\S+) (?P<port>\\S+)'\n  convert: {method: lowercase, port: int, missing: int}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(prepareLine(StreamLine{line: "GET 080"}, config).labels).To(Equal(map[string]string{"method": "get", "port": "80"}))
+		})
+	})
+
+	It("quotes values again when they are overwritten", func() {
+		m := NewOrderedMap()
+		m.SetRaw("a", "1")
+		m.SetRaw("b", "2")
+		copied := m.Copy()
+		m.Set("a", "1")
+		Expect(m.ToJson()).To(Equal("{\"a\":\"1\",\"b\":2}"))
+		Expect(copied.ToJson()).To(Equal("{\"a\":1,\"b\":2}"))
+		m.Release()
+		Expect(m.raw).To(BeEmpty())
+	})
+
+	It("fails on unknown conversions", func() {
+		withConfig("---\npatterns:\n- regex: hi\n  convert: {a: int, b: nope}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("patterns[0].convert.b must be one of bool, bytes, duration, float, int, lowercase, trim, urldecode but was nope"))
+		})
+	})
+})
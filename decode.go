@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var formats = []string{"", "cri", "docker"}
+
+// partial lines that are never completed are sent once they reach this size, so they do not grow memory forever
+var maxPartialLine = 1024 * 1024
+
+// unwraps container runtime log envelopes and reassembles lines they split up
+// not safe for concurrent use, each source needs its own decoder
+type Decoder struct {
+	format  string
+	partial map[int]string // by stream since stdout and stderr are interleaved
+}
+
+type dockerLine struct {
+	Log    string `json:"log"`
+	Stream string `json:"stream"`
+	Time   string `json:"time"`
+}
+
+func NewDecoder(format string) *Decoder {
+	return &Decoder{format: format, partial: map[int]string{}}
+}
+
+func validateFormat(format string) error {
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("format must be one of cri/docker but was %s", format)
+}
+
+// decode the line, returns false when the line is partial and we need to wait for the rest
+func (d *Decoder) Decode(line StreamLine) (StreamLine, bool) {
+	switch d.format {
+	case "cri":
+		return d.decodeCri(line)
+	case "docker":
+		return d.decodeDocker(line)
+	default:
+		return line, true
+	}
+}
+
+// 2024-01-01T00:00:00.000000000Z stdout F message
+func (d *Decoder) decodeCri(line StreamLine) (StreamLine, bool) {
+	parts := strings.SplitN(line.line, " ", 4)
+	if len(parts) < 3 {
+		return line, true
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return line, true
+	}
+	message := ""
+	if len(parts) == 4 {
+		message = parts[3]
+	}
+	return d.reassemble(line, t, parts[1], message, parts[2] == "P")
+}
+
+// {"log":"message\n","stream":"stdout","time":"2024-01-01T00:00:00.000000000Z"}
+// lines without trailing newline were split because they were too long
+func (d *Decoder) decodeDocker(line StreamLine) (StreamLine, bool) {
+	if !strings.HasPrefix(line.line, "{") {
+		return line, true
+	}
+	var parsed dockerLine
+	if err := json.Unmarshal([]byte(line.line), &parsed); err != nil {
+		return line, true
+	}
+	t, _ := time.Parse(time.RFC3339Nano, parsed.Time) // zero time when missing, so we use the current time
+	message, complete := strings.CutSuffix(parsed.Log, "\n")
+	return d.reassemble(line, t, parsed.Stream, message, !complete)
+}
+
+func (d *Decoder) reassemble(line StreamLine, t time.Time, stream string, message string, partial bool) (StreamLine, bool) {
+	if stream == "stderr" {
+		line.index = 1
+	} else {
+		line.index = 0
+	}
+
+	if partial && len(d.partial[line.index])+len(message) < maxPartialLine {
+		d.partial[line.index] += message
+		return line, false
+	}
+
+	line.line = d.partial[line.index] + message
+	line.time = t
+	delete(d.partial, line.index)
+	return line, true
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("decode", func() {
+	// decode all lines and return the complete ones
+	decode := func(format string, lines ...string) (decoded []StreamLine) {
+		decoder := NewDecoder(format)
+		for _, l := range lines {
+			if line, complete := decoder.Decode(StreamLine{line: l}); complete {
+				decoded = append(decoded, line)
+			}
+		}
+		return
+	}
+
+	t := time.Date(2024, 1, 1, 0, 0, 0, 123000000, time.UTC)
+
+	It("does nothing without format", func() {
+		Expect(decode("", "hi")).To(Equal([]StreamLine{{line: "hi"}}))
+	})
+
+	Describe("cri", func() {
+		It("decodes", func() {
+			Expect(decode("cri", "2024-01-01T00:00:00.123Z stdout F hi there", "2024-01-01T00:00:00.123Z stderr F ho")).
+				To(Equal([]StreamLine{{line: "hi there", time: t}, {index: 1, line: "ho", time: t}}))
+		})
+
+		It("decodes empty lines", func() {
+			Expect(decode("cri", "2024-01-01T00:00:00.123Z stdout F")).To(Equal([]StreamLine{{line: "", time: t}}))
+		})
+
+		It("reassembles partial lines per stream", func() {
+			Expect(decode("cri",
+				"2024-01-01T00:00:00.123Z stdout P h",
+				"2024-01-01T00:00:00.123Z stderr F ho",
+				"2024-01-01T00:00:00.123Z stdout P i",
+				"2024-01-01T00:00:00.123Z stdout F !",
+			)).To(Equal([]StreamLine{{index: 1, line: "ho", time: t}, {line: "hi!", time: t}}))
+		})
+
+		It("sends partial lines that get too long", func() {
+			old := maxPartialLine
+			defer func() { maxPartialLine = old }()
+			maxPartialLine = 4
+			Expect(decode("cri",
+				"2024-01-01T00:00:00.123Z stdout P ab",
+				"2024-01-01T00:00:00.123Z stdout P cd",
+				"2024-01-01T00:00:00.123Z stdout P e",
+				"2024-01-01T00:00:00.123Z stdout F f",
+			)).To(Equal([]StreamLine{{line: "abcd", time: t}, {line: "ef", time: t}}))
+		})
+
+		It("keeps lines that are not cri", func() {
+			Expect(decode("cri", "hi", "hi there you")).To(Equal([]StreamLine{{line: "hi"}, {line: "hi there you"}}))
+		})
+	})
+
+	Describe("docker", func() {
+		It("decodes", func() {
+			Expect(decode("docker", `{"log":"hi\n","stream":"stderr","time":"2024-01-01T00:00:00.123Z"}`)).
+				To(Equal([]StreamLine{{index: 1, line: "hi", time: t}}))
+		})
+
+		It("reassembles long lines", func() {
+			Expect(decode("docker", `{"log":"h","stream":"stdout","time":"2024-01-01T00:00:00.123Z"}`, `{"log":"i\n","stream":"stdout","time":"2024-01-01T00:00:00.123Z"}`)).
+				To(Equal([]StreamLine{{line: "hi", time: t}}))
+		})
+
+		It("keeps lines that are not docker", func() {
+			Expect(decode("docker", "hi", "{nope}")).To(Equal([]StreamLine{{line: "hi"}, {line: "{nope}"}}))
+		})
+	})
+
+	It("fails on unknown format", func() {
+		withConfig("---\nformat: wut", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("format must be one of cri/docker but was wut"))
+		})
+	})
+
+	It("fails on unknown input format", func() {
+		withConfig("---\ninputs:\n- file: foo\n  format: wut", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("format must be one of cri/docker but was wut"))
+		})
+	})
+
+	It("uses the global format for files", func() {
+		withConfig("---\nformat: cri\ninputs:\n- file: foo\n- file: bar\n  format: docker", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(config.files()).To(Equal([]Input{{File: "foo", Format: "cri"}, {File: "bar", Format: "docker"}}))
+		})
+	})
+
+	It("writes decoded lines to where they came from", func() {
+		withConfig("---\nformat: cri\ntimestampKey: ts", func() {
+			var out string
+			err := captureStderr(func() {
+				out = parse("2024-01-01T00:00:00.123Z stdout F hi\n2024-01-01T00:00:00Z stderr F ho")
+			})
+			Expect(out).To(Equal(`{"ts":"2024-01-01T00:00:00Z","message":"hi"}`))
+			Expect(strings.TrimRight(err, "\n")).To(Equal(`{"ts":"2024-01-01T00:00:00Z","message":"ho"}`))
+		})
+	})
+})
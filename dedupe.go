@@ -0,0 +1,123 @@
+package main
+
+import (
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	repeatedKey  = "repeated"
+	firstSeenKey = "first_seen"
+	lastSeenKey  = "last_seen"
+)
+
+// collapses consecutive identical lines of a stream (same fields except the timestamp) into the first line
+// and a summary with how often it was repeated, so crash loops do not flood the output
+type Dedupe struct {
+	Window string // collapse repeats this long after the first line, default 10s
+	window time.Duration
+	mutex  sync.Mutex
+	runs   map[string]*dedupeRun // by stream
+}
+
+type dedupeRun struct {
+	key      string
+	started  time.Time // when the run started, to close the window even when lines are not timestamped by now
+	first    time.Time
+	last     time.Time
+	repeated int
+	log      *OrderedMap // first line, to build the summary from
+	labels   map[string]string
+	stream   string
+}
+
+func (d *Dedupe) setup() (err error) {
+	if d.window, err = parseDuration(d.Window, 10*time.Second, "dedupe.window"); err != nil {
+		return err
+	}
+	d.runs = map[string]*dedupeRun{}
+	return nil
+}
+
+// identical lines of the same stream share a key, everything but the timestamp has to be the same
+// so json lines with the same message but different fields are not collapsed
+func dedupeKey(stream string, log *OrderedMap, timestampKey string) string {
+	keys := append([]string{}, log.keys...)
+	sort.Strings(keys) // json fields come in random order
+	var key strings.Builder
+	key.WriteString(stream)
+	for _, k := range keys {
+		if k == timestampKey {
+			continue
+		}
+		key.WriteByte(0)
+		key.WriteString(k)
+		key.WriteByte(0)
+		key.WriteString(log.values[k])
+	}
+	return key.String()
+}
+
+// if the line repeats the previous line of its stream, and the summary of the run it ended if any
+func (d *Dedupe) track(processed *processedLine, now time.Time) (duplicate bool, ended *dedupeRun) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	run := d.runs[processed.stream]
+	if run != nil && run.key == processed.dedupeKey && now.Sub(run.started) < d.window {
+		run.repeated++
+		run.last = processed.time
+		return true, nil
+	}
+	if run != nil && run.repeated != 0 {
+		ended = run
+	}
+	d.runs[processed.stream] = &dedupeRun{
+		key:     processed.dedupeKey,
+		started: now,
+		first:   processed.time,
+		last:    processed.time,
+		log:     processed.full,
+		labels:  maps.Clone(processed.labels), // reused for the next line
+		stream:  processed.stream,
+	}
+	return false, ended
+}
+
+// runs whose window closed (or all when stopping) that had repeats
+func (d *Dedupe) summaries(now time.Time, all bool) (summaries []*dedupeRun) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for stream, run := range d.runs {
+		if all || now.Sub(run.started) >= d.window {
+			if run.repeated != 0 {
+				summaries = append(summaries, run)
+			}
+			delete(d.runs, stream)
+		}
+	}
+	return summaries
+}
+
+// the first line with how often it was repeated and when
+func writeDedupeSummary(config *Config, run *dedupeRun) {
+	log := run.log
+	if config.timestampKeySet {
+		log.values[config.TimestampKey] = run.last.Format(timeFormat)
+	}
+	log.SetRaw(repeatedKey, strconv.Itoa(run.repeated)) // a number so it can be summed
+	log.Set(firstSeenKey, run.first.Format(timeFormat))
+	log.Set(lastSeenKey, run.last.Format(timeFormat))
+	writeSummary(config, log, run.stream, run.last, run.labels)
+}
+
+func writeDedupeSummaries(config *Config, all bool) {
+	for _, run := range config.Dedupe.summaries(time.Now(), all) {
+		writeDedupeSummary(config, run)
+	}
+}
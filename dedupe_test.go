@@ -0,0 +1,170 @@
+package main
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dedupe", func() {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// process lines a second apart and return what was written to stdout
+	process := func(config string, lines ...StreamLine) (stdout string, c *Config) {
+		withConfig(config, func() {
+			var err error
+			c, err = NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			stdout = captureStdout(func() {
+				c.Output.Start()
+				stop := startSummaries(c)
+				for i, line := range lines {
+					line.time = start.Add(time.Duration(i) * time.Second)
+					processLine(line, c)
+				}
+				stop()
+				c.Output.Stop()
+			})
+		})
+		return
+	}
+
+	log := func(kv ...string) *OrderedMap {
+		m := NewOrderedMap()
+		for i := 0; i < len(kv); i += 2 {
+			m.Set(kv[i], kv[i+1])
+		}
+		return m
+	}
+
+	lines := func(messages ...string) (lines []StreamLine) {
+		for _, message := range messages {
+			lines = append(lines, StreamLine{line: message})
+		}
+		return
+	}
+
+	It("collapses repeated lines into the first and a summary", func() {
+		stdout, _ := process("---\ndedupe: {window: 1m}", lines("a", "a", "a", "b", "c", "c")...)
+		Expect(stdout).To(Equal(
+			"{\"message\":\"a\"}\n" +
+				"{\"message\":\"a\",\"repeated\":2,\"first_seen\":\"2020-01-02T03:04:05Z\",\"last_seen\":\"2020-01-02T03:04:07Z\"}\n" +
+				"{\"message\":\"b\"}\n" +
+				"{\"message\":\"c\"}\n" +
+				"{\"message\":\"c\",\"repeated\":1,\"first_seen\":\"2020-01-02T03:04:09Z\",\"last_seen\":\"2020-01-02T03:04:10Z\"}\n",
+		))
+	})
+
+	It("compares messages after preprocessing and patterns", func() {
+		stdout, _ := process("---\nlevelKey: level\nglog: simple\ndedupe: {}\npatterns:\n- regex: 'took \\d+ms'\n  add: {message: slow}",
+			lines("I0102 03:04:05.000000 1 a.go:1] took 1ms", "I0102 03:04:06.000000 1 a.go:1] took 2ms", "E0102 03:04:07.000000 1 a.go:1] took 3ms")...)
+		Expect(stdout).To(Equal(
+			"{\"level\":\"INFO\",\"message\":\"slow\"}\n" +
+				"{\"level\":\"INFO\",\"message\":\"slow\",\"repeated\":1,\"first_seen\":\"2020-01-02T03:04:05Z\",\"last_seen\":\"2020-01-02T03:04:06Z\"}\n" +
+				"{\"level\":\"ERROR\",\"message\":\"slow\"}\n",
+		))
+	})
+
+	It("does not collapse lines that differ in other fields", func() {
+		stdout, _ := process("---\njson: simple\ndedupe: {}",
+			lines(`{"user":"alice"}`, `{"user":"bob"}`, `{"user":"bob"}`)...)
+		Expect(stdout).To(Equal(
+			"{\"message\":\"\",\"user\":\"alice\"}\n" +
+				"{\"message\":\"\",\"user\":\"bob\"}\n" +
+				"{\"message\":\"\",\"user\":\"bob\",\"repeated\":1,\"first_seen\":\"2020-01-02T03:04:06Z\",\"last_seen\":\"2020-01-02T03:04:07Z\"}\n",
+		))
+	})
+
+	It("ignores the order of fields", func() {
+		Expect(dedupeKey("stdout", log("a", "1", "b", "2", "ts", "x"), "ts")).To(Equal(dedupeKey("stdout", log("b", "2", "a", "1", "ts", "y"), "ts")))
+		Expect(dedupeKey("stdout", log("a", "1"), "")).ToNot(Equal(dedupeKey("stdout", log("a", "2"), "")))
+	})
+
+	It("dedupes every stream separately", func() {
+		stdout, _ := process("---\ndedupe: {}\noutput:\n  stderr: {streams: []}",
+			StreamLine{line: "a"}, StreamLine{line: "a", index: 1}, StreamLine{line: "a"})
+		Expect(stdout).To(Equal(
+			"{\"message\":\"a\"}\n" +
+				"{\"message\":\"a\"}\n" +
+				"{\"message\":\"a\",\"repeated\":1,\"first_seen\":\"2020-01-02T03:04:05Z\",\"last_seen\":\"2020-01-02T03:04:07Z\"}\n",
+		))
+	})
+
+	It("starts a new run when the window closed", func() {
+		d := &Dedupe{Window: "1m"}
+		Expect(d.setup()).To(BeNil())
+		line := &processedLine{dedupeKey: "a", time: start}
+		Expect(d.track(line, start)).To(BeFalse())
+		duplicate, ended := d.track(line, start.Add(time.Second))
+		Expect(duplicate).To(BeTrue())
+		Expect(ended).To(BeNil())
+		duplicate, ended = d.track(line, start.Add(time.Minute))
+		Expect(duplicate).To(BeFalse())
+		Expect(ended.repeated).To(Equal(1))
+
+		Expect(d.summaries(start.Add(time.Minute), false)).To(BeEmpty())
+		Expect(d.runs).ToNot(BeEmpty())
+		Expect(d.summaries(start.Add(2*time.Minute), false)).To(BeEmpty()) // nothing repeated
+		Expect(d.runs).To(BeEmpty())
+	})
+
+	It("counts repeated lines in metrics and gives summaries to sinks", func() {
+		withConfig("---\ndedupe: {}\npatterns:\n- regex: a\n  add: {pattern: a}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			sink := &recordingSink{}
+			config.Output.sinks = []Sink{sink}
+			config.Otlp = &Otlp{metrics: true, counts: map[string]*otlpCount{}, Labels: config.possibleLabels()}
+
+			captureStdout(func() {
+				config.Output.Start()
+				for i := 0; i < 3; i++ {
+					processLine(StreamLine{line: "a"}, config)
+				}
+				writeDedupeSummaries(config, true)
+				config.Output.Stop()
+			})
+
+			Expect(config.Otlp.counts[`"pattern"="a"`].value).To(Equal(uint64(3)))
+			Expect(len(sink.entries)).To(Equal(2))
+			Expect(sink.entries[1].labels).To(Equal(map[string]string{"pattern": "a"}))
+			Expect(sink.entries[1].log.values[repeatedKey]).To(Equal("2"))
+		})
+	})
+
+	It("writes summaries periodically", func() {
+		original := summaryInterval
+		summaryInterval = time.Millisecond
+		defer func() { summaryInterval = original }()
+
+		withConfig("---\ntimestampKey: ts\ndedupe: {window: 50ms}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			stdout := captureStdout(func() {
+				config.Output.Start()
+				stop := startSummaries(config)
+				processLine(StreamLine{line: "a", time: start}, config)
+				processLine(StreamLine{line: "a", time: start.Add(time.Second)}, config)
+				Eventually(func() int {
+					config.Dedupe.mutex.Lock()
+					defer config.Dedupe.mutex.Unlock()
+					return len(config.Dedupe.runs)
+				}).Should(Equal(0))
+				stop()
+				config.Output.Stop()
+			})
+			Expect(stdout).To(Equal(
+				"{\"ts\":\"2020-01-02T03:04:05Z\",\"message\":\"a\"}\n" +
+					"{\"ts\":\"2020-01-02T03:04:06Z\",\"message\":\"a\",\"repeated\":1,\"first_seen\":\"2020-01-02T03:04:05Z\",\"last_seen\":\"2020-01-02T03:04:06Z\"}\n",
+			))
+		})
+	})
+
+	It("fails on invalid window", func() {
+		withConfig("---\ndedupe: {window: long}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("dedupe.window must be a positive duration like 1s or 5m but was long"))
+		})
+	})
+})
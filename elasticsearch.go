@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var elasticsearchBackoff = 100 * time.Millisecond
+
+// indexes logs via the bulk api, works with elasticsearch and opensearch
+// https://www.elastic.co/guide/en/elasticsearch/reference/current/docs-bulk.html
+type Elasticsearch struct {
+	Url           string // for example http://elasticsearch:9200
+	Index         string // for example logs-{2006.01.02} to get daily indices
+	Username      string
+	Password      string
+	FlushBytes    int    `yaml:"flushBytes"`    // bulk request size, default 5MB
+	FlushInterval string `yaml:"flushInterval"` // max time to wait for a bulk request to fill, default 1s
+	BufferSize    int    `yaml:"bufferSize"`    // logs to keep in memory while elasticsearch is slow, default 10000
+	MaxRetries    int    `yaml:"maxRetries"`    // retries with backoff before giving up on a bulk request, default 10
+	DeadLetter    string `yaml:"deadLetter"`    // file to append logs that could not be indexed to, dropped when not set
+	flushInterval time.Duration
+	batcher       batcher
+	body          bytes.Buffer
+	documents     []string // to know which document failed
+	client        *http.Client
+}
+
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+type bulkItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+func (e *Elasticsearch) setup() (err error) {
+	if !strings.HasPrefix(e.Url, "http://") && !strings.HasPrefix(e.Url, "https://") {
+		return fmt.Errorf("output.elasticsearch.url must start with http:// or https:// but was %s", e.Url)
+	}
+	if e.Index == "" {
+		return fmt.Errorf("output.elasticsearch.index must be set")
+	}
+	if e.FlushBytes == 0 {
+		e.FlushBytes = 5 * 1024 * 1024
+	}
+	if e.BufferSize == 0 {
+		e.BufferSize = 10000
+	}
+	if e.MaxRetries == 0 {
+		e.MaxRetries = 10
+	}
+	if e.flushInterval, err = parseDuration(e.FlushInterval, time.Second, "output.elasticsearch.flushInterval"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (e *Elasticsearch) Name() string {
+	return "elasticsearch"
+}
+
+func (e *Elasticsearch) Start() {
+	e.client = &http.Client{Timeout: 30 * time.Second}
+	e.batcher.start(e.BufferSize, e.flushInterval, e.add, e.flush)
+}
+
+func (e *Elasticsearch) Stop() {
+	e.batcher.stop()
+}
+
+func (e *Elasticsearch) Write(entry *Entry) {
+	e.batcher.write(entry)
+}
+
+func (e *Elasticsearch) Dropped() *atomic.Uint64 {
+	return &e.batcher.dropped
+}
+
+func (e *Elasticsearch) index(t time.Time) string {
+	return formatTimeTemplate(e.Index, t)
+}
+
+// add the action + document ndjson lines
+func (e *Elasticsearch) add(entry *Entry) bool {
+	action, err := json.Marshal(map[string]map[string]string{"index": {"_index": e.index(entry.time)}})
+	check(err)
+	document := entry.log.ToJson()
+
+	e.body.Write(action)
+	e.body.WriteByte('\n')
+	e.body.WriteString(document)
+	e.body.WriteByte('\n')
+	e.documents = append(e.documents, document)
+
+	return e.body.Len() >= e.FlushBytes
+}
+
+// send the bulk request, retrying when elasticsearch is down or overloaded
+func (e *Elasticsearch) flush() {
+	documents := e.documents
+	if len(documents) == 0 {
+		return
+	}
+	body := make([]byte, e.body.Len())
+	copy(body, e.body.Bytes())
+	e.body.Reset()
+	e.documents = nil
+
+	url := strings.TrimRight(e.Url, "/") + "/_bulk"
+	headers := map[string]string{"Content-Type": "application/x-ndjson"}
+	if e.Username != "" {
+		headers["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(e.Username+":"+e.Password))
+	}
+
+	status, response, err := sendWithRetries(e.MaxRetries, elasticsearchBackoff, e.batcher.stopping, func() (int, []byte, error) {
+		return post(e.client, url, headers, body)
+	})
+	if err == nil && status/100 == 2 {
+		e.handleItemErrors(documents, response)
+		return
+	}
+
+	message := fmt.Sprintf("bulk request failed with status %d", status)
+	if err != nil {
+		message = "bulk request failed: " + err.Error()
+	}
+	reason, _ := json.Marshal(message)
+	for _, document := range documents {
+		e.deadLetter(document, reason)
+	}
+}
+
+// the bulk request can succeed while individual documents fail, for example on mapping conflicts
+func (e *Elasticsearch) handleItemErrors(documents []string, content []byte) {
+	var response bulkResponse
+	if err := json.Unmarshal(content, &response); err != nil || !response.Errors {
+		return
+	}
+	for i, item := range response.Items {
+		for _, result := range item {
+			if result.Status >= 300 && i < len(documents) {
+				e.deadLetter(documents[i], result.Error)
+			}
+		}
+	}
+}
+
+// append the failed document with its error, count it as dropped when that is not possible
+func (e *Elasticsearch) deadLetter(document string, reason json.RawMessage) {
+	if e.DeadLetter == "" {
+		e.batcher.dropped.Add(1)
+		return
+	}
+
+	if len(reason) == 0 {
+		reason = json.RawMessage("null")
+	}
+	line, err := json.Marshal(map[string]json.RawMessage{"error": reason, "document": json.RawMessage(document)})
+	check(err)
+
+	file, err := os.OpenFile(e.DeadLetter, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		e.batcher.dropped.Add(1)
+		return
+	}
+	defer file.Close()
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		e.batcher.dropped.Add(1) // untested section
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("elasticsearch", func() {
+	var server *httptest.Server
+	var received []string
+	var responses []string
+	var statuses []int
+	var mutex sync.Mutex
+	var dir string
+
+	BeforeEach(func() {
+		elasticsearchBackoff = time.Millisecond
+		received = nil
+		responses = nil
+		statuses = nil
+		var err error
+		dir, err = os.MkdirTemp("", "logrecycler")
+		Expect(err).To(BeNil())
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/_bulk"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+			body, err := io.ReadAll(r.Body)
+			Expect(err).To(BeNil())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			status := http.StatusOK
+			if len(statuses) != 0 {
+				status, statuses = statuses[0], statuses[1:]
+			}
+			response := `{"errors":false}`
+			if len(responses) != 0 {
+				response, responses = responses[0], responses[1:]
+			}
+			if status == http.StatusOK {
+				received = append(received, string(body))
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(response))
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	entry := func(message string) *Entry {
+		log := NewOrderedMap()
+		log.Set("message", message)
+		return &Entry{time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), log: log}
+	}
+
+	start := func(elasticsearch *Elasticsearch) *Elasticsearch {
+		elasticsearch.Url = server.URL
+		if elasticsearch.Index == "" {
+			elasticsearch.Index = "logs-{2006.01.02}"
+		}
+		Expect(elasticsearch.setup()).To(BeNil())
+		elasticsearch.Start()
+		return elasticsearch
+	}
+
+	deadLetters := func() string {
+		content, _ := os.ReadFile(filepath.Join(dir, "dead.json"))
+		return string(content)
+	}
+
+	It("indexes in bulk", func() {
+		elasticsearch := start(&Elasticsearch{})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Write(entry("b"))
+		elasticsearch.Stop()
+		Expect(received).To(Equal([]string{
+			`{"index":{"_index":"logs-2024.01.02"}}` + "\n" + `{"message":"a"}` + "\n" +
+				`{"index":{"_index":"logs-2024.01.02"}}` + "\n" + `{"message":"b"}` + "\n",
+		}))
+	})
+
+	It("authenticates", func() {
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			Expect([]interface{}{username, password, ok}).To(Equal([]interface{}{"user", "pass", true}))
+			mutex.Lock()
+			defer mutex.Unlock()
+			received = append(received, "auth")
+		})
+		elasticsearch := start(&Elasticsearch{Username: "user", Password: "pass"})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Stop()
+		Expect(received).To(Equal([]string{"auth"}))
+	})
+
+	It("flushes when the request is big enough", func() {
+		elasticsearch := start(&Elasticsearch{FlushBytes: 1, Index: "logs"})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Write(entry("b"))
+		elasticsearch.Stop()
+		Expect(received).To(Equal([]string{
+			`{"index":{"_index":"logs"}}` + "\n" + `{"message":"a"}` + "\n",
+			`{"index":{"_index":"logs"}}` + "\n" + `{"message":"b"}` + "\n",
+		}))
+	})
+
+	It("flushes when waited long enough", func() {
+		elasticsearch := start(&Elasticsearch{FlushInterval: "10ms"})
+		elasticsearch.Write(entry("a"))
+		Eventually(func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(received)
+		}).Should(Equal(1))
+		elasticsearch.Stop()
+	})
+
+	It("retries when elasticsearch is overloaded", func() {
+		statuses = []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}
+		elasticsearch := start(&Elasticsearch{})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Stop()
+		Expect(received).To(HaveLen(1))
+	})
+
+	It("dead letters documents that failed to index", func() {
+		responses = []string{`{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}},{"index":{"status":500}}]}`}
+		elasticsearch := start(&Elasticsearch{DeadLetter: filepath.Join(dir, "dead.json")})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Write(entry("b"))
+		elasticsearch.Write(entry("c"))
+		elasticsearch.Stop()
+		Expect(deadLetters()).To(Equal(
+			`{"document":{"message":"b"},"error":{"type":"mapper_parsing_exception"}}` + "\n" +
+				`{"document":{"message":"c"},"error":null}` + "\n",
+		))
+		Expect(elasticsearch.Dropped().Load()).To(Equal(uint64(0)))
+	})
+
+	It("ignores unparseable responses", func() {
+		responses = []string{`nope`}
+		elasticsearch := start(&Elasticsearch{DeadLetter: filepath.Join(dir, "dead.json")})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Stop()
+		Expect(deadLetters()).To(Equal(""))
+	})
+
+	It("dead letters when elasticsearch rejects", func() {
+		statuses = []int{http.StatusBadRequest}
+		elasticsearch := start(&Elasticsearch{DeadLetter: filepath.Join(dir, "dead.json")})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Stop()
+		Expect(deadLetters()).To(Equal(`{"document":{"message":"a"},"error":"bulk request failed with status 400"}` + "\n"))
+	})
+
+	It("drops when elasticsearch stays down without dead letter", func() {
+		elasticsearch := &Elasticsearch{Url: "http://127.0.0.1:1", Index: "logs", MaxRetries: 1}
+		Expect(elasticsearch.setup()).To(BeNil())
+		elasticsearch.Start()
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Stop()
+		Expect(elasticsearch.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("dead letters when elasticsearch stays down", func() {
+		elasticsearch := &Elasticsearch{Url: "http://127.0.0.1:1", Index: "logs", MaxRetries: 1, DeadLetter: filepath.Join(dir, "dead.json")}
+		Expect(elasticsearch.setup()).To(BeNil())
+		elasticsearch.Start()
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Stop()
+		Expect(deadLetters()).To(ContainSubstring(`"error":"bulk request failed: Post`))
+	})
+
+	It("drops when dead letter cannot be written", func() {
+		statuses = []int{http.StatusBadRequest}
+		elasticsearch := start(&Elasticsearch{DeadLetter: filepath.Join(dir, "missing", "dead.json")})
+		elasticsearch.Write(entry("a"))
+		elasticsearch.Stop()
+		Expect(elasticsearch.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("has a name", func() {
+		Expect((&Elasticsearch{}).Name()).To(Equal("elasticsearch"))
+	})
+
+	It("fails on invalid url", func() {
+		withConfig("---\noutput:\n  elasticsearch:\n    url: es:9200", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.elasticsearch.url must start with http:// or https:// but was es:9200"))
+		})
+	})
+
+	It("fails without index", func() {
+		withConfig("---\noutput:\n  elasticsearch:\n    url: http://es:9200", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.elasticsearch.index must be set"))
+		})
+	})
+
+	It("fails on invalid flushInterval", func() {
+		withConfig("---\noutput:\n  elasticsearch:\n    url: http://es:9200\n    index: logs\n    flushInterval: nope", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.elasticsearch.flushInterval must be a positive duration like 1s or 5m but was nope"))
+		})
+	})
+
+	It("indexes everything that was logged", func() {
+		withConfig("---\nlevelKey: level\noutput:\n  format: logfmt\n  elasticsearch:\n    url: "+server.URL+"\n    index: logs", func() {
+			Expect(parse("hi")).To(Equal("level=INFO message=hi"))
+		})
+		Expect(received).To(Equal([]string{`{"index":{"_index":"logs"}}` + "\n" + `{"level":"INFO","message":"hi"}` + "\n"}))
+	})
+})
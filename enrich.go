@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v2"
+)
+
+// adds the columns of the row whose key matches a field, for example the team and runbook of an error_code,
+// the file is loaded on startup and reloaded on SIGHUP
+type Enrich struct {
+	Field        string            // look up the value of this field, for example a capture
+	File         string            // csv with a header row, or json/yaml with a list of rows or rows by key
+	Key          string            // column to match the field with, default is the field name
+	Defaults     map[string]string // added when no row matched
+	MetricLabels bool              `yaml:"metricLabels"` // use the added columns as metric labels
+	location     string
+	defaults     []string // sorted keys of Defaults
+	table        atomic.Pointer[enrichTable]
+}
+
+type enrichTable struct {
+	columns []string // in the order of the file, without the key
+	rows    map[string]map[string]string
+}
+
+func (e *Enrich) setup(location string) error {
+	e.location = location
+	if e.Field == "" {
+		return fmt.Errorf("%s.field is required", location)
+	}
+	if e.Key == "" {
+		e.Key = e.Field
+	}
+	e.defaults = keys(e.Defaults)
+	sort.Strings(e.defaults)
+	return e.load()
+}
+
+func (e *Enrich) load() error {
+	table, err := loadEnrichTable(e.File, e.Key)
+	if err != nil {
+		return fmt.Errorf("%s.file could not be loaded: %v", e.location, err)
+	}
+	e.table.Store(table)
+	return nil
+}
+
+// reload the file, keeping the old rows when the new file is broken
+func (e *Enrich) Reopen() {
+	if err := e.load(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+}
+
+// returns added with the keys that were set and should stay out of metrics appended
+func (e *Enrich) apply(log *OrderedMap, added []string) []string {
+	if value, found := log.values[e.Field]; found {
+		table := e.table.Load()
+		if row, found := table.rows[value]; found {
+			for _, column := range table.columns {
+				if value, found := row[column]; found {
+					log.Set(column, value)
+					added = e.added(added, column)
+				}
+			}
+			return added
+		}
+	}
+	for _, key := range e.defaults {
+		log.Set(key, e.Defaults[key])
+		added = e.added(added, key)
+	}
+	return added
+}
+
+func (e *Enrich) added(added []string, key string) []string {
+	if e.MetricLabels {
+		return added
+	}
+	return append(added, key)
+}
+
+// everything that can be added, columns that only appear when the file is reloaded are not included
+func (e *Enrich) labels() []string {
+	return unique(append(append([]string{}, e.table.Load().columns...), e.defaults...))
+}
+
+func loadEnrichTable(path string, key string) (*enrichTable, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []map[string]string
+	var columns []string
+	switch filepath.Ext(path) {
+	case ".csv":
+		records, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, fmt.Errorf("no header row")
+		}
+		columns = records[0]
+		for _, record := range records[1:] {
+			row := map[string]string{}
+			for i, column := range columns {
+				row[column] = record[i]
+			}
+			rows = append(rows, row)
+		}
+	case ".json":
+		if rows, err = parseEnrichRows(content, key, json.Unmarshal, jsonString); err != nil {
+			return nil, err
+		}
+	case ".yaml", ".yml":
+		if rows, err = parseEnrichRows(content, key, yaml.Unmarshal, func(value any) string { return fmt.Sprintf("%v", value) }); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("must end in .csv, .json, .yaml or .yml")
+	}
+
+	// columns of json/yaml rows have no order, so sort them
+	if columns == nil {
+		for _, row := range rows {
+			columns = append(columns, keys(row)...)
+		}
+		columns = unique(columns)
+		sort.Strings(columns)
+	}
+	if !contains(columns, key) {
+		return nil, fmt.Errorf("has no %s column", key)
+	}
+
+	table := &enrichTable{columns: removeElement(columns, key), rows: map[string]map[string]string{}}
+	for _, row := range rows {
+		table.rows[row[key]] = row
+	}
+	return table, nil
+}
+
+// a list of rows, or rows by key like `{"E1": {"team": "db"}}`
+func parseEnrichRows(content []byte, key string, unmarshal func([]byte, any) error, format func(any) string) ([]map[string]string, error) {
+	var list []map[string]any
+	if err := unmarshal(content, &list); err == nil {
+		rows := make([]map[string]string, len(list))
+		for i, row := range list {
+			rows[i] = map[string]string{}
+			for column, value := range row {
+				rows[i][column] = format(value)
+			}
+		}
+		return rows, nil
+	}
+
+	var byKey map[string]map[string]any
+	if err := unmarshal(content, &byKey); err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0, len(byKey))
+	for k, row := range byKey {
+		converted := map[string]string{key: k}
+		for column, value := range row {
+			converted[column] = format(value)
+		}
+		rows = append(rows, converted)
+	}
+	return rows, nil
+}
+
+// enrich files to reload on SIGHUP
+func (c *Config) reopeners() []reopener {
+	reopeners := make([]reopener, len(c.Enrich))
+	for i := range c.Enrich {
+		reopeners[i] = &c.Enrich[i]
+	}
+	return reopeners
+}
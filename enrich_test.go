@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("enrich", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "logrecycler")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0644)).To(BeNil())
+		return path
+	}
+
+	enriched := func(e *Enrich, kv ...string) string {
+		m := NewOrderedMap()
+		for i := 0; i < len(kv); i += 2 {
+			m.Set(kv[i], kv[i+1])
+		}
+		e.apply(m, nil)
+		return m.ToJson()
+	}
+
+	It("adds columns of the matching row", func() {
+		path := write("codes.csv", "code,team,runbook\nE1,db,https://runbooks/e1\nE2,web,\n")
+		withConfig("---\nenrich:\n- field: error_code\n  file: "+path+"\n  key: code\n  defaults: {team: unknown}\npatterns:\n- regex: 'failed with (?P<error_code>\\S+)'", func() {
+			Expect(parse("failed with E1\nfailed with E2\nfailed with E3\nhi")).To(Equal(
+				"{\"message\":\"failed with E1\",\"error_code\":\"E1\",\"team\":\"db\",\"runbook\":\"https://runbooks/e1\"}\n" +
+					"{\"message\":\"failed with E2\",\"error_code\":\"E2\",\"team\":\"web\",\"runbook\":\"\"}\n" +
+					"{\"message\":\"failed with E3\",\"error_code\":\"E3\",\"team\":\"unknown\"}\n" +
+					"{\"message\":\"hi\",\"team\":\"unknown\"}",
+			))
+		})
+	})
+
+	It("reads json and yaml lists or rows by key", func() {
+		for name, content := range map[string]string{
+			"list.json":   `[{"tenant_id": "1", "plan": "free", "seats": 3}]`,
+			"keyed.json":  `{"1": {"plan": "free", "seats": 3}}`,
+			"list.yaml":   "- {tenant_id: 1, plan: free, seats: 3}",
+			"keyed.yml":   "'1': {plan: free, seats: 3}",
+			"other.yaml":  "- {tenant_id: 2, plan: paid}\n- {tenant_id: 1, plan: free, seats: 3}",
+			"missing.csv": "tenant_id,plan,seats\n2,paid,1\n1,free,3",
+		} {
+			e := &Enrich{Field: "tenant_id", File: write(name, content)}
+			Expect(e.setup("enrich")).To(BeNil())
+			Expect(enriched(e, "tenant_id", "1")).To(Equal(`{"tenant_id":"1","plan":"free","seats":"3"}`), name)
+		}
+	})
+
+	It("keeps enriched fields out of metrics unless asked", func() {
+		path := write("codes.csv", "code,team\nE1,db\n")
+		owners := write("owners.csv", "code,owner\nE1,alice\n")
+		withConfig("---\nenrich:\n- {field: code, file: "+owners+", defaults: {owner: nobody}}\n- {field: code, file: "+path+", key: code, metricLabels: true}\npatterns:\n- regex: '(?P<code>E\\d)'\n  ignoreMetricLabels: [code]", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(config.possibleLabels()).To(Equal([]string{"team"}))
+			Expect(prepareLine(StreamLine{line: "E1"}, config).labels).To(Equal(map[string]string{"team": "db"}))
+		})
+		withConfig("---\nenrich:\n- {field: code, file: "+path+", defaults: {owner: nobody}}\npatterns:\n- regex: '(?P<code>E\\d)'", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(prepareLine(StreamLine{line: "E2"}, config).labels).To(Equal(map[string]string{"code": "E2"}))
+		})
+	})
+
+	It("keeps labels with the same name as a column when enrich did not add them", func() {
+		path := write("codes.csv", "code,team\nE1,db\n")
+		withConfig("---\nenrich:\n- {field: code, file: "+path+"}\npatterns:\n- regex: '(?P<code>E\\d) (?P<team>\\w+)'", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(prepareLine(StreamLine{line: "E9 web"}, config).labels).To(Equal(map[string]string{"code": "E9", "team": "web"}))
+			Expect(prepareLine(StreamLine{line: "E1 web"}, config).labels).To(Equal(map[string]string{"code": "E1"}))
+		})
+	})
+
+	It("reloads on SIGHUP and keeps the old rows when the file is broken", func() {
+		path := write("codes.csv", "code,team\nE1,db\n")
+		withConfig("---\nenrich:\n- {field: code, file: "+path+"}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(config.reopeners()).To(Equal([]reopener{&config.Enrich[0]}))
+		})
+
+		e := &Enrich{Field: "code", File: path}
+		Expect(e.setup("enrich[0]")).To(BeNil())
+		write("codes.csv", "code,team\nE1,web\n")
+		e.Reopen()
+		Expect(enriched(e, "code", "E1")).To(Equal(`{"code":"E1","team":"web"}`))
+
+		write("codes.csv", "team\n")
+		Expect(captureStderr(func() { e.Reopen() })).To(Equal("Error: enrich[0].file could not be loaded: has no code column\n"))
+		Expect(enriched(e, "code", "E1")).To(Equal(`{"code":"E1","team":"web"}`))
+	})
+
+	It("fails on invalid settings", func() {
+		for content, message := range map[string]string{
+			"{}":                           "enrich[0].field is required",
+			"field: a":                     "enrich[0].file could not be loaded: open : no such file or directory",
+			"field: a\n  file: x.txt":      "enrich[0].file could not be loaded: open x.txt: no such file or directory",
+			"field: a\n  file: DIR/x.txt":  "enrich[0].file could not be loaded: must end in .csv, .json, .yaml or .yml",
+			"field: a\n  file: DIR/e.csv":  "enrich[0].file could not be loaded: no header row",
+			"field: a\n  file: DIR/b.csv":  "enrich[0].file could not be loaded: record on line 2: wrong number of fields",
+			"field: a\n  file: DIR/b.json": "enrich[0].file could not be loaded: json: cannot unmarshal string into Go value of type map[string]map[string]interface {}",
+			"field: a\n  file: DIR/b.yml":  "enrich[0].file could not be loaded: yaml: unmarshal errors:\n  line 1: cannot unmarshal !!str `nope` into map[string]map[string]interface {}",
+		} {
+			write("x.txt", "")
+			write("e.csv", "")
+			write("b.csv", "a,b\n1\n")
+			write("b.json", `"nope"`)
+			write("b.yml", "nope")
+			withConfig("---\nenrich:\n- "+strings.ReplaceAll(content, "DIR", dir), func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err).ToNot(BeNil())
+				Expect(err.Error()).To(Equal(strings.ReplaceAll(message, "DIR", dir)))
+			})
+		}
+	})
+})
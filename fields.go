@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// remove, rename or copy fields, for example noisy json fields or `lvl` that should be the levelKey,
+// copy happens first, then rename, then remove
+type FieldOperations struct {
+	Remove  []string
+	Rename  map[string]string // from -> to, to takes the position of from unless it already exists
+	Copy    map[string]string // from -> to
+	renames []fieldPair
+	copies  []fieldPair
+}
+
+type fieldPair struct {
+	from string
+	to   string
+}
+
+func (f *FieldOperations) setup() {
+	f.renames = sortedPairs(f.Rename)
+	f.copies = sortedPairs(f.Copy)
+}
+
+// sorted so operations happen in the same order every time
+func sortedPairs(pairs map[string]string) []fieldPair {
+	sorted := make([]fieldPair, 0, len(pairs))
+	for from, to := range pairs {
+		sorted = append(sorted, fieldPair{from: from, to: to})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].from < sorted[j].from })
+	return sorted
+}
+
+func (f *FieldOperations) apply(log *OrderedMap) {
+	for _, pair := range f.copies {
+		log.CopyKey(pair.from, pair.to)
+	}
+	for _, pair := range f.renames {
+		log.Rename(pair.from, pair.to)
+	}
+	for _, key := range f.Remove {
+		log.Delete(key)
+	}
+}
+
+// labels after the operations, assuming copied and renamed fields could always be there
+func (f *FieldOperations) labels(labels []string) []string {
+	for _, pair := range f.copies {
+		labels = append(labels, pair.to)
+	}
+	for _, pair := range f.renames {
+		labels = append(removeElement(labels, pair.from), pair.to)
+	}
+	for _, key := range f.Remove {
+		labels = removeElement(labels, key)
+	}
+	return labels
+}
+
+// field added to every line
+type globalField struct {
+	key   string
+	value string
+	raw   string // as configured, to fill in the child pid once the command started
+}
+
+// fields like `service: my_app` or `pod: ${POD}` with the built-ins ${hostname}, ${pid} and ${child_pid}, $$ is a literal $
+func (c *Config) setupFields() error {
+	for _, item := range c.Fields {
+		key, ok := item.Key.(string)
+		if !ok {
+			return fmt.Errorf("fields keys must be strings but was %v", item.Key)
+		}
+		raw := ""
+		if item.Value != nil { // `key:` without a value
+			raw = fmt.Sprintf("%v", item.Value)
+		}
+		c.fields = append(c.fields, globalField{key: key, value: expandField(raw, ""), raw: raw})
+	}
+	return nil
+}
+
+// validate the fields prometheus should use as const labels
+func (c *Config) setupConstLabels() error {
+	possible := c.possibleLabels()
+	for i, name := range c.Prometheus.ConstLabels {
+		if !validLabelName(name) || strings.HasPrefix(name, "__") {
+			return fmt.Errorf("prometheus.constLabels[%d] must be a name like [a-zA-Z_][a-zA-Z0-9_]* not starting with __ but was %s", i, name)
+		}
+		field := c.field(name)
+		if field == nil {
+			return fmt.Errorf("prometheus.constLabels[%d] must be one of the fields but was %s", i, name)
+		}
+		if strings.Contains(field.raw, "child_pid") {
+			return fmt.Errorf("prometheus.constLabels[%d] cannot use child_pid since the command starts after prometheus", i)
+		}
+		if contains(possible, name) {
+			return fmt.Errorf("prometheus.constLabels[%d] cannot be %s since it is also a metric label from captures or add", i, name)
+		}
+	}
+	return nil
+}
+
+// remove const labels that still have the value of the field, prometheus adds them to every metric
+func (c *Config) removeConstLabels(values map[string]string) {
+	for _, name := range c.Prometheus.ConstLabels {
+		if value, found := values[name]; found && value == c.field(name).value {
+			delete(values, name)
+		}
+	}
+}
+
+// values of the const labels, for prometheus to add to every metric
+func (c *Config) constLabels() map[string]string {
+	labels := map[string]string{}
+	for _, name := range c.Prometheus.ConstLabels {
+		labels[name] = c.field(name).value
+	}
+	return labels
+}
+
+func (c *Config) field(key string) *globalField {
+	for i := range c.fields {
+		if c.fields[i].key == key {
+			return &c.fields[i]
+		}
+	}
+	return nil
+}
+
+// fill in ${child_pid} before lines are processed
+func (c *Config) setChildPid(pid int) {
+	for i := range c.fields {
+		c.fields[i].value = expandField(c.fields[i].raw, strconv.Itoa(pid))
+	}
+}
+
+func expandField(raw string, childPid string) string {
+	return os.Expand(raw, func(name string) string {
+		switch name {
+		case "hostname":
+			hostname, _ := os.Hostname()
+			return hostname
+		case "pid":
+			return strconv.Itoa(os.Getpid())
+		case "child_pid":
+			return childPid
+		case "$":
+			return "$"
+		default:
+			return os.Getenv(name)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("fields", func() {
+	log := func(kv ...string) *OrderedMap {
+		m := NewOrderedMap()
+		for i := 0; i < len(kv); i += 2 {
+			m.Set(kv[i], kv[i+1])
+		}
+		return m
+	}
+
+	It("removes, renames and copies keeping the order", func() {
+		m := log("a", "1", "b", "2", "c", "3", "d", "4")
+		m.SetRaw("c", "3")
+		operations := FieldOperations{Remove: []string{"d", "missing"}, Rename: map[string]string{"b": "x", "c": "a", "missing": "y"}, Copy: map[string]string{"c": "z", "missing": "y"}}
+		operations.setup()
+		operations.apply(m)
+		Expect(m.ToJson()).To(Equal(`{"a":3,"x":"2","z":3}`))
+	})
+
+	It("does nothing when renaming to the same key", func() {
+		m := log("a", "1")
+		m.Rename("a", "a")
+		Expect(m.ToJson()).To(Equal(`{"a":"1"}`))
+	})
+
+	It("renames json fields to the configured keys", func() {
+		withConfig("---\nlevelKey: level\njson: simple\nrename: {lvl: level, msg: message}\nremove: [noise]\npatterns:\n- regex: hi\n  copy: {user: account}\n  remove: [user]\n  convert: {account: lowercase}", func() {
+			Expect(parse(`{"lvl":"ERROR","msg":"hi","noise":"x","user":"BOB"}`)).To(Equal(
+				`{"level":"ERROR","message":"hi","account":"bob"}`,
+			))
+		})
+	})
+
+	It("updates metric labels", func() {
+		withConfig("---\nlevelKey: level\nrename: {level: severity}\npatterns:\n- regex: '(?P<a>\\S+) (?P<b>\\S+)'\n  rename: {a: c}\n  copy: {b: d}\n  remove: [b]", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(config.possibleLabels()).To(Equal([]string{"severity", "d", "c"}))
+			Expect(prepareLine(StreamLine{line: "1 2"}, config).labels).To(Equal(map[string]string{"severity": "INFO", "c": "1", "d": "2"}))
+		})
+	})
+})
+
+var _ = Describe("global fields", func() {
+	It("adds fields to every line after timestamp and level", func() {
+		os.Setenv("LOGRECYCLER_ENV", "prod")
+		defer os.Unsetenv("LOGRECYCLER_ENV")
+		hostname, _ := os.Hostname()
+		withConfig("---\nlevelKey: level\nfields:\n  service: my_app\n  env: ${LOGRECYCLER_ENV}\n  host: $hostname\n  pid: ${pid}\n  version: 1.2\n  price: $$5\n  empty:", func() {
+			Expect(parse("hi")).To(Equal(
+				"{\"level\":\"INFO\",\"service\":\"my_app\",\"env\":\"prod\",\"host\":\"" + hostname + "\",\"pid\":\"" + strconv.Itoa(os.Getpid()) + "\",\"version\":\"1.2\",\"price\":\"$5\",\"empty\":\"\",\"message\":\"hi\"}",
+			))
+		})
+	})
+
+	It("adds the pid of the command", func() {
+		withConfig("---\nfields: {child: '${child_pid}'}", func() {
+			match := regexp.MustCompile(`^\{"child":"(\d+)","message":"(\d+)"\}$`).FindStringSubmatch(runWithCommand("sh", "-c", "echo $$"))
+			Expect(match).ToNot(BeNil())
+			Expect(match[1]).To(Equal(match[2])) // the shell prints its own pid
+		})
+	})
+
+	It("only removes const labels that still have the value of the field from metric labels", func() {
+		withConfig("---\nfields: {service: my_app, env: prod}\nprometheus: {constLabels: [service]}\npatterns:\n- regex: '(?P<env>dev) hi'\n  add: {pattern: hi}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(prepareLine(StreamLine{line: "dev hi"}, config).labels).To(Equal(map[string]string{"env": "dev", "pattern": "hi"}))
+			Expect(prepareLine(StreamLine{line: "hi"}, config).labels).To(Equal(map[string]string{"env": "prod"}))
+		})
+	})
+
+	It("adds fields as prometheus const labels", func() {
+		port := randomPort()
+		withConfig("---\nfields: {service: my_app, env: prod}\nprometheus:\n  port: "+port+"\n  constLabels: [service]", func() {
+			Expect(prometheusMetrics(port)).To(Equal("# HELP logs_total Total number of logs received\n# TYPE logs_total counter\nlogs_total{service=\"my_app\"} 1\n"))
+		})
+	})
+
+	It("fails on invalid fields", func() {
+		for config, message := range map[string]string{
+			"---\nfields: {1: a}":                                                                        "fields keys must be strings but was 1",
+			"---\nprometheus: {constLabels: [a]}":                                                        "prometheus.constLabels[0] must be one of the fields but was a",
+			"---\nfields: {a: '${child_pid}'}\nprometheus: {constLabels: [a]}":                           "prometheus.constLabels[0] cannot use child_pid since the command starts after prometheus",
+			"---\nfields: {a: x}\nprometheus: {constLabels: [a]}\npatterns:\n- regex: hi\n  add: {a: y}": "prometheus.constLabels[0] cannot be a since it is also a metric label from captures or add",
+			"---\nfields: {service.name: x}\nprometheus: {constLabels: [service.name]}":                  "prometheus.constLabels[0] must be a name like [a-zA-Z_][a-zA-Z0-9_]* not starting with __ but was service.name",
+			"---\nfields: {__a: x}\nprometheus: {constLabels: [__a]}":                                    "prometheus.constLabels[0] must be a name like [a-zA-Z_][a-zA-Z0-9_]* not starting with __ but was __a",
+		} {
+			withConfig(config, func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal(message))
+			})
+		}
+	})
+})
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const rotatedTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// writes logs to a file that is rotated by size and age, rotated files are named path.<time>
+type FileOutput struct {
+	Path       string // for example /var/log/app-{2006-01-02}.json, {...} is replaced with the log time in go time format
+	MaxSize    string `yaml:"maxSize"`  // rotate when the file would get bigger, for example 100MB
+	MaxAge     string `yaml:"maxAge"`   // rotate when the file was opened longer ago, for example 24h
+	MaxFiles   int    `yaml:"maxFiles"` // rotated files and files of earlier templated paths to keep, all when not set
+	Compress   bool   // gzip rotated files
+	BufferSize int    `yaml:"bufferSize"` // logs to keep in memory while the disk is slow, default 10000
+	maxSize    int64
+	maxAge     time.Duration
+	path       string // of the open file
+	file       *os.File
+	writer     *bufio.Writer
+	size       int64
+	opened     time.Time
+	reopen     atomic.Bool
+	batcher    batcher
+}
+
+func (f *FileOutput) setup() (err error) {
+	if f.Path == "" {
+		return fmt.Errorf("output.file.path must be set")
+	}
+	if f.maxSize, err = parseBytes(f.MaxSize, "output.file.maxSize"); err != nil {
+		return err
+	}
+	if f.MaxAge != "" {
+		if f.maxAge, err = parseDuration(f.MaxAge, 0, "output.file.maxAge"); err != nil {
+			return err
+		}
+	}
+	if f.BufferSize == 0 {
+		f.BufferSize = 10000
+	}
+	return nil
+}
+
+func (f *FileOutput) Name() string {
+	return "file"
+}
+
+func (f *FileOutput) Start() {
+	f.batcher.start(f.BufferSize, time.Second, f.add, f.flush)
+}
+
+func (f *FileOutput) Stop() {
+	f.batcher.stop()
+	f.close()
+}
+
+func (f *FileOutput) Write(entry *Entry) {
+	f.batcher.write(entry)
+}
+
+func (f *FileOutput) Dropped() *atomic.Uint64 {
+	return &f.batcher.dropped
+}
+
+// reopen the file before the next write, for example after logrotate moved it
+func (f *FileOutput) Reopen() {
+	f.reopen.Store(true)
+}
+
+func (f *FileOutput) add(entry *Entry) bool {
+	line := entry.line + "\n"
+	path := formatTimeTemplate(f.Path, entry.time)
+	switched := f.path != "" && path != f.path
+
+	if f.reopen.Swap(false) || path != f.path {
+		f.close()
+	} else if f.file != nil && (f.tooOld() || (f.maxSize != 0 && f.size != 0 && f.size+int64(len(line)) > f.maxSize)) {
+		f.rotate()
+	}
+
+	if f.file == nil {
+		if err := f.open(path); err != nil {
+			f.batcher.dropped.Add(1)
+			return false
+		}
+		if switched && f.MaxFiles != 0 {
+			f.removeOldFiles()
+		}
+	}
+	written, _ := f.writer.WriteString(line) // errors are remembered by the writer and reported on flush
+	f.size += int64(written)
+	return false
+}
+
+// make logs visible to readers of the file and rotate even when nothing is written
+func (f *FileOutput) flush() {
+	if f.reopen.Swap(false) {
+		f.close()
+	} else if f.file != nil && f.tooOld() {
+		f.rotate()
+	} else if f.file != nil {
+		_ = f.writer.Flush()
+	}
+}
+
+func (f *FileOutput) tooOld() bool {
+	return f.maxAge != 0 && time.Since(f.opened) >= f.maxAge
+}
+
+func (f *FileOutput) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close() // untested section
+		return err
+	}
+
+	f.path = path
+	f.file = file
+	f.writer = bufio.NewWriter(file)
+	f.size = stat.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+func (f *FileOutput) close() {
+	if f.file == nil {
+		return
+	}
+	_ = f.writer.Flush()
+	_ = f.file.Close()
+	f.file = nil
+	f.path = ""
+}
+
+// move the file aside so the next write starts a new one, then compress and remove old files
+func (f *FileOutput) rotate() {
+	path := f.path
+	f.close()
+
+	rotated := path + "." + time.Now().Format(rotatedTimeFormat)
+	if err := os.Rename(path, rotated); err != nil {
+		return // untested section
+	}
+	if f.Compress {
+		if err := gzipFile(rotated); err == nil {
+			_ = os.Remove(rotated)
+		}
+	}
+	if f.MaxFiles != 0 {
+		f.removeOldFiles()
+	}
+}
+
+// remove the oldest files that were rotated or written for an earlier time in the path, never the open file
+func (f *FileOutput) removeOldFiles() {
+	glob := timeTemplateGlob(f.Path)
+	current, _ := filepath.Glob(glob)
+	rotated, _ := filepath.Glob(glob + ".*")
+	files := []string{}
+	modified := map[string]time.Time{}
+	for _, file := range unique(append(current, rotated...)) {
+		if stat, err := os.Stat(file); err == nil && file != f.path {
+			files = append(files, file)
+			modified[file] = stat.ModTime()
+		}
+	}
+	sort.Strings(files) // rotated files of the same path that were modified at the same time sort by their time
+	sort.SliceStable(files, func(i, j int) bool { return modified[files[i]].Before(modified[files[j]]) })
+	for len(files) > f.MaxFiles {
+		_ = os.Remove(files[0])
+		files = files[1:]
+	}
+}
+
+// glob that matches the path for every time, for example log-{2006}.json becomes log-*.json
+func timeTemplateGlob(template string) string {
+	glob := ""
+	last := 0
+	for _, match := range timeTemplateRegex.FindAllStringIndex(template, -1) {
+		glob += globEscape(template[last:match[0]]) + "*"
+		last = match[1]
+	}
+	return glob + globEscape(template[last:])
+}
+
+func gzipFile(path string) error {
+	source, err := os.Open(path)
+	if err != nil {
+		return err // untested section
+	}
+	defer source.Close()
+
+	target, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(target)
+	if _, err = io.Copy(writer, source); err != nil {
+		_ = target.Close() // untested section
+		return err
+	}
+	if err = writer.Close(); err != nil {
+		_ = target.Close() // untested section
+		return err
+	}
+	return target.Close()
+}
+
+// so paths with [ or * only match themselves
+func globEscape(path string) string {
+	escaped := []rune{}
+	for _, r := range path {
+		if r == '*' || r == '?' || r == '[' || r == '\\' {
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, r)
+	}
+	return string(escaped)
+}
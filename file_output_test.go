@@ -0,0 +1,246 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("file output", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "logrecycler")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	entry := func(line string) *Entry {
+		return &Entry{time: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), line: line}
+	}
+
+	output := func(f *FileOutput) *FileOutput {
+		if f.Path == "" {
+			f.Path = filepath.Join(dir, "log.json")
+		}
+		Expect(f.setup()).To(BeNil())
+		return f
+	}
+
+	read := func(path string) string {
+		content, err := os.ReadFile(path)
+		Expect(err).To(BeNil())
+		return string(content)
+	}
+
+	rotated := func() []string {
+		files, err := filepath.Glob(filepath.Join(dir, "log.json.*"))
+		Expect(err).To(BeNil())
+		sort.Strings(files)
+		return files
+	}
+
+	It("writes to templated paths", func() {
+		f := output(&FileOutput{Path: filepath.Join(dir, "{2006}", "log-{01-02}.json")})
+		f.Start()
+		f.Write(entry("a"))
+		f.Write(entry("b"))
+		f.Stop()
+		Expect(read(filepath.Join(dir, "2020", "log-01-02.json"))).To(Equal("a\nb\n"))
+		Expect(f.Dropped().Load()).To(Equal(uint64(0)))
+		Expect(f.Name()).To(Equal("file"))
+	})
+
+	It("appends to existing files", func() {
+		path := filepath.Join(dir, "log.json")
+		Expect(os.WriteFile(path, []byte("old\n"), 0644)).To(BeNil())
+		f := output(&FileOutput{})
+		f.add(entry("new"))
+		f.close()
+		Expect(read(path)).To(Equal("old\nnew\n"))
+	})
+
+	It("switches files when the time in the path changes", func() {
+		f := output(&FileOutput{Path: filepath.Join(dir, "{2006}.json")})
+		f.add(entry("a"))
+		later := entry("b")
+		later.time = later.time.AddDate(1, 0, 0)
+		f.add(later)
+		f.close()
+		Expect(read(filepath.Join(dir, "2020.json"))).To(Equal("a\n"))
+		Expect(read(filepath.Join(dir, "2021.json"))).To(Equal("b\n"))
+	})
+
+	It("rotates by size and keeps the newest files", func() {
+		f := output(&FileOutput{MaxSize: "4B", MaxFiles: 1})
+		for _, line := range []string{"a", "b", "c", "d", "e"} {
+			f.add(entry(line))
+		}
+		f.close()
+		Expect(read(filepath.Join(dir, "log.json"))).To(Equal("e\n"))
+		files := rotated()
+		Expect(len(files)).To(Equal(1))
+		Expect(read(files[0])).To(Equal("c\nd\n"))
+	})
+
+	It("keeps the newest files of templated paths", func() {
+		f := output(&FileOutput{Path: filepath.Join(dir, "log-{2006}.json"), MaxFiles: 1})
+		for i := 0; i < 3; i++ {
+			later := entry("a")
+			later.time = later.time.AddDate(i, 0, 0)
+			f.add(later)
+		}
+		f.close()
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		Expect(err).To(BeNil())
+		sort.Strings(files)
+		Expect(files).To(Equal([]string{filepath.Join(dir, "log-2021.json"), filepath.Join(dir, "log-2022.json")}))
+	})
+
+	It("does not rotate empty files for big lines", func() {
+		f := output(&FileOutput{MaxSize: "1"})
+		f.add(entry("big"))
+		f.close()
+		Expect(rotated()).To(BeEmpty())
+	})
+
+	It("rotates by age", func() {
+		f := output(&FileOutput{MaxAge: "1ms"})
+		f.add(entry("a"))
+		time.Sleep(2 * time.Millisecond)
+		f.flush()
+		Expect(f.file).To(BeNil())
+		f.add(entry("b"))
+		time.Sleep(2 * time.Millisecond)
+		f.add(entry("c"))
+		f.close()
+
+		Expect(read(filepath.Join(dir, "log.json"))).To(Equal("c\n"))
+		files := rotated()
+		Expect(len(files)).To(Equal(2))
+		Expect(read(files[0])).To(Equal("a\n"))
+		Expect(read(files[1])).To(Equal("b\n"))
+	})
+
+	It("compresses rotated files", func() {
+		f := output(&FileOutput{MaxSize: "2", Compress: true})
+		f.add(entry("a"))
+		f.add(entry("b"))
+		f.close()
+
+		files := rotated()
+		Expect(len(files)).To(Equal(1))
+		Expect(files[0]).To(HaveSuffix(".gz"))
+		file, err := os.Open(files[0])
+		Expect(err).To(BeNil())
+		defer file.Close()
+		reader, err := gzip.NewReader(file)
+		Expect(err).To(BeNil())
+		content, err := io.ReadAll(reader)
+		Expect(err).To(BeNil())
+		Expect(string(content)).To(Equal("a\n"))
+	})
+
+	It("keeps rotated files it could not compress", func() {
+		path := filepath.Join(dir, "log.json")
+		Expect(os.WriteFile(path, []byte("a\n"), 0644)).To(BeNil())
+		Expect(os.Mkdir(path+".gz", 0755)).To(BeNil())
+		Expect(gzipFile(path)).ToNot(BeNil())
+	})
+
+	It("flushes so readers see logs", func() {
+		f := output(&FileOutput{})
+		f.flush() // nothing open yet
+		f.add(entry("a"))
+		Expect(read(filepath.Join(dir, "log.json"))).To(Equal(""))
+		f.flush()
+		Expect(read(filepath.Join(dir, "log.json"))).To(Equal("a\n"))
+		f.close()
+	})
+
+	It("reopens on SIGHUP after logrotate moved the file", func() {
+		path := filepath.Join(dir, "log.json")
+		f := output(&FileOutput{})
+		defer reopenOnHangup([]Sink{&Loki{}, f})()
+		f.add(entry("a"))
+		Expect(os.Rename(path, path+".1")).To(BeNil())
+
+		Expect(syscall.Kill(os.Getpid(), syscall.SIGHUP)).To(BeNil())
+		Eventually(f.reopen.Load).Should(BeTrue())
+		f.add(entry("b"))
+		f.Reopen()
+		f.flush()
+		Expect(f.file).To(BeNil())
+		f.close()
+
+		Expect(read(path + ".1")).To(Equal("a\n"))
+		Expect(read(path)).To(Equal("b\n"))
+	})
+
+	It("drops when the file cannot be opened", func() {
+		Expect(os.WriteFile(filepath.Join(dir, "file"), nil, 0644)).To(BeNil())
+		f := output(&FileOutput{Path: filepath.Join(dir, "file", "log.json")})
+		f.add(entry("a"))
+		Expect(f.Dropped().Load()).To(Equal(uint64(1)))
+
+		f = output(&FileOutput{Path: dir})
+		f.add(entry("a"))
+		Expect(f.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("escapes globs", func() {
+		Expect(globEscape(`a*b?c[d\e`)).To(Equal(`a\*b\?c\[d\\e`))
+	})
+
+	It("parses sizes", func() {
+		for value, expected := range map[string]int64{"": 0, "12": 12, "12B": 12, "2kb": 2048, "1 MB": 1 << 20, "3GB": 3 << 30} {
+			size, err := parseBytes(value, "size")
+			Expect(err).To(BeNil())
+			Expect(size).To(Equal(expected))
+		}
+		for _, value := range []string{"1TB", "MB", "99999999999999999999"} {
+			_, err := parseBytes(value, "size")
+			Expect(err.Error()).To(Equal("size must be a size like 512KB or 100MB but was " + value))
+		}
+	})
+
+	It("writes logs when configured", func() {
+		path := filepath.Join(dir, "log.json")
+		withConfig("output:\n  file:\n    path: "+path+"\n", func() {
+			Expect(runWithCommand("echo", "hi")).To(Equal(`{"message":"hi"}`))
+		})
+		Expect(read(path)).To(Equal("{\"message\":\"hi\"}\n"))
+	})
+
+	It("fails without path", func() {
+		withConfig("output:\n  file:\n    compress: true", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.file.path must be set"))
+		})
+	})
+
+	It("fails on invalid maxSize", func() {
+		withConfig("output:\n  file:\n    path: log.json\n    maxSize: big", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.file.maxSize must be a size like 512KB or 100MB but was big"))
+		})
+	})
+
+	It("fails on invalid maxAge", func() {
+		withConfig("output:\n  file:\n    path: log.json\n    maxAge: old", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.file.maxAge must be a positive duration like 1s or 5m but was old"))
+		})
+	})
+})
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+const maxGelfChunks = 128
+
+var gelfCompressions = []string{"gzip", "zlib", "none"}
+
+// additional field names graylog accepts
+var gelfInvalidFieldChars = regexp.MustCompile(`[^\w.\-]`)
+
+// forwards logs to graylog https://go2docs.graylog.org/current/getting_in_log_data/gelf.html
+type Gelf struct {
+	Address      string // for example udp://graylog:12201 or tcp://graylog:12201
+	Compression  string // for udp: gzip (default), zlib or none
+	ChunkSize    int    `yaml:"chunkSize"` // max udp packet size before splitting into chunks, default 1420
+	Hostname     string // default hostname of the machine
+	BufferSize   int    `yaml:"bufferSize"` // logs to keep in memory while the receiver is slow, default 10000
+	connection   *connection
+	batcher      batcher
+	timestampKey string
+	levelKey     string
+	messageKey   string
+}
+
+func (g *Gelf) setup(config *Config) (err error) {
+	if g.connection, err = newConnection(g.Address, "output.gelf.address"); err != nil {
+		return err
+	}
+	if g.Compression == "" {
+		g.Compression = "gzip"
+	}
+	if !contains(gelfCompressions, g.Compression) {
+		return fmt.Errorf("output.gelf.compression must be one of gzip/zlib/none but was %s", g.Compression)
+	}
+	if g.ChunkSize == 0 {
+		g.ChunkSize = 1420
+	}
+	if g.ChunkSize <= 12 {
+		return fmt.Errorf("output.gelf.chunkSize must be greater than 12 but was %d", g.ChunkSize)
+	}
+	if g.Hostname == "" {
+		g.Hostname = defaultHostname()
+	}
+	if g.BufferSize == 0 {
+		g.BufferSize = 10000
+	}
+	g.timestampKey = config.TimestampKey
+	g.levelKey = config.LevelKey
+	g.messageKey = config.MessageKey
+	return nil
+}
+
+func (g *Gelf) Name() string {
+	return "gelf"
+}
+
+func (g *Gelf) Start() {
+	g.batcher.start(g.BufferSize, time.Second, g.add, func() {})
+}
+
+func (g *Gelf) Stop() {
+	g.batcher.stop()
+	g.connection.close()
+}
+
+func (g *Gelf) Write(entry *Entry) {
+	g.batcher.write(entry)
+}
+
+func (g *Gelf) Dropped() *atomic.Uint64 {
+	return &g.batcher.dropped
+}
+
+// send right away, udp messages are compressed and chunked, tcp messages are null terminated
+func (g *Gelf) add(entry *Entry) bool {
+	message := g.format(entry)
+
+	var err error
+	if g.connection.network == "udp" {
+		err = g.sendChunked(g.compress(message))
+	} else {
+		err = g.connection.write(append(message, 0))
+	}
+	if err != nil {
+		g.batcher.dropped.Add(1)
+	}
+	return false
+}
+
+// fields become additional fields prefixed with _
+func (g *Gelf) format(entry *Entry) []byte {
+	message := map[string]interface{}{
+		"version":       "1.1",
+		"host":          g.Hostname,
+		"short_message": entry.log.values[g.messageKey],
+		"timestamp":     float64(entry.time.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         syslogSeverity(entry.level),
+	}
+	if message["short_message"] == "" {
+		message["short_message"] = "-" // required to not be empty
+	}
+	for _, key := range entry.log.keys {
+		if key == g.messageKey || key == g.timestampKey || key == g.levelKey {
+			continue
+		}
+		name := gelfInvalidFieldChars.ReplaceAllString(key, "_")
+		if name == "id" {
+			name = "id_" // _id is reserved
+		}
+		message["_"+name] = entry.log.values[key]
+	}
+
+	content, err := json.Marshal(message)
+	check(err)
+	return content
+}
+
+func (g *Gelf) compress(message []byte) []byte {
+	var compressed bytes.Buffer
+	var writer io.WriteCloser
+	switch g.Compression {
+	case "gzip":
+		writer = gzip.NewWriter(&compressed)
+	case "zlib":
+		writer = zlib.NewWriter(&compressed)
+	default:
+		return message
+	}
+	_, err := writer.Write(message)
+	check(err)
+	check(writer.Close())
+	return compressed.Bytes()
+}
+
+// split into chunks with a 12 byte header: magic bytes, message id, sequence number and count
+func (g *Gelf) sendChunked(message []byte) error {
+	if len(message) <= g.ChunkSize {
+		return g.connection.write(message)
+	}
+
+	size := g.ChunkSize - 12
+	count := (len(message) + size - 1) / size
+	if count > maxGelfChunks {
+		return fmt.Errorf("message needs %d chunks but gelf allows at most %d", count, maxGelfChunks)
+	}
+	id := make([]byte, 8)
+	_, err := rand.Read(id)
+	check(err)
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(message) {
+			end = len(message)
+		}
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, message[i*size:end]...)
+		if err = g.connection.write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
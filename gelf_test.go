@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("gelf", func() {
+	BeforeEach(func() {
+		connectionBackoff = time.Millisecond
+	})
+
+	entry := func(level string, keys ...string) *Entry {
+		log := NewOrderedMap()
+		for i := 0; i < len(keys); i += 2 {
+			log.Set(keys[i], keys[i+1])
+		}
+		return &Entry{time: time.Unix(1, 234000000), level: level, log: log}
+	}
+
+	output := func(gelf *Gelf) *Gelf {
+		gelf.Hostname = "host"
+		Expect(gelf.setup(&Config{TimestampKey: "ts", LevelKey: "level", MessageKey: "message"})).To(BeNil())
+		return gelf
+	}
+
+	listenUdp := func() net.PacketConn {
+		packet, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		Expect(packet.SetReadDeadline(time.Now().Add(time.Second))).To(BeNil())
+		return packet
+	}
+
+	readPacket := func(packet net.PacketConn) []byte {
+		buf := make([]byte, 64*1024)
+		n, _, err := packet.ReadFrom(buf)
+		Expect(err).To(BeNil())
+		return buf[:n]
+	}
+
+	decompress := func(reader io.Reader, err error) string {
+		Expect(err).To(BeNil())
+		content, err := io.ReadAll(reader)
+		Expect(err).To(BeNil())
+		return string(content)
+	}
+
+	It("formats fields as additional fields", func() {
+		gelf := output(&Gelf{Address: "udp://127.0.0.1:12201"})
+		Expect(string(gelf.format(entry("ERROR", "ts", "x", "level", "ERROR", "message", "hi", "id", "1", "a b", "2")))).To(Equal(
+			`{"_a_b":"2","_id_":"1","host":"host","level":3,"short_message":"hi","timestamp":1.234,"version":"1.1"}`,
+		))
+	})
+
+	It("formats without message", func() {
+		gelf := output(&Gelf{Address: "udp://127.0.0.1:12201"})
+		Expect(string(gelf.format(entry("")))).To(Equal(
+			`{"host":"host","level":5,"short_message":"-","timestamp":1.234,"version":"1.1"}`,
+		))
+	})
+
+	It("sends gzipped over udp", func() {
+		packet := listenUdp()
+		defer packet.Close()
+		gelf := output(&Gelf{Address: "udp://" + packet.LocalAddr().String()})
+		gelf.Start()
+		gelf.Write(entry("INFO", "message", "hi"))
+		gelf.Stop()
+		Expect(decompress(gzip.NewReader(bytes.NewReader(readPacket(packet))))).To(ContainSubstring(`"short_message":"hi"`))
+		Expect(gelf.Dropped().Load()).To(Equal(uint64(0)))
+		Expect(gelf.Name()).To(Equal("gelf"))
+	})
+
+	It("sends zlib compressed over udp", func() {
+		packet := listenUdp()
+		defer packet.Close()
+		gelf := output(&Gelf{Address: "udp://" + packet.LocalAddr().String(), Compression: "zlib"})
+		gelf.add(entry("INFO", "message", "hi"))
+		Expect(decompress(zlib.NewReader(bytes.NewReader(readPacket(packet))))).To(ContainSubstring(`"short_message":"hi"`))
+		gelf.connection.close()
+	})
+
+	It("splits big messages into chunks", func() {
+		packet := listenUdp()
+		defer packet.Close()
+		gelf := output(&Gelf{Address: "udp://" + packet.LocalAddr().String(), Compression: "none", ChunkSize: 50})
+		gelf.add(entry("INFO", "message", strings.Repeat("a", 100)))
+		gelf.connection.close()
+
+		first := readPacket(packet)
+		Expect(first[0:2]).To(Equal([]byte{0x1e, 0x0f}))
+		Expect(first[10]).To(Equal(byte(0)))
+		count := int(first[11])
+		Expect(count).To(BeNumerically(">", 2))
+		message := first[12:]
+		for i := 1; i < count; i++ {
+			chunk := readPacket(packet)
+			Expect(chunk[0:10]).To(Equal(first[0:10]))
+			Expect(chunk[10:12]).To(Equal([]byte{byte(i), byte(count)}))
+			message = append(message, chunk[12:]...)
+		}
+		Expect(string(message)).To(ContainSubstring(`"short_message":"` + strings.Repeat("a", 100) + `"`))
+	})
+
+	It("drops messages that need too many chunks", func() {
+		gelf := output(&Gelf{Address: "udp://127.0.0.1:12201", Compression: "none", ChunkSize: 13})
+		gelf.add(entry("INFO", "message", strings.Repeat("a", 200)))
+		Expect(gelf.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("drops chunks when the receiver is down", func() {
+		gelf := output(&Gelf{Address: "udp://127.0.0.1:12201", Compression: "none", ChunkSize: 50})
+		gelf.connection.retryAt = time.Now().Add(time.Hour)
+		gelf.add(entry("INFO", "message", strings.Repeat("a", 100)))
+		Expect(gelf.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("sends null terminated over tcp", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer listener.Close()
+
+		gelf := output(&Gelf{Address: "tcp://" + listener.Addr().String()})
+		gelf.Start()
+		gelf.Write(entry("INFO", "message", "a"))
+		gelf.Write(entry("INFO", "message", "b"))
+		gelf.Stop()
+
+		conn, err := listener.Accept()
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		first, err := reader.ReadString(0)
+		Expect(err).To(BeNil())
+		Expect(first).To(HavePrefix("{"))
+		Expect(first).To(ContainSubstring(`"short_message":"a"`))
+		second, err := reader.ReadString(0)
+		Expect(err).To(BeNil())
+		Expect(second).To(ContainSubstring(`"short_message":"b"`))
+	})
+
+	It("forwards logs when configured", func() {
+		packet := listenUdp()
+		defer packet.Close()
+		withConfig("output:\n  gelf:\n    address: udp://"+packet.LocalAddr().String()+"\n    compression: none\n", func() {
+			Expect(runWithCommand("echo", "hi")).To(Equal(`{"message":"hi"}`))
+		})
+		Expect(string(readPacket(packet))).To(ContainSubstring(`"short_message":"hi"`))
+	})
+
+	It("fails on invalid address", func() {
+		withConfig("output:\n  gelf:\n    address: graylog:12201", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.gelf.address must start with udp://, tcp:// or tls:// but was graylog:12201"))
+		})
+	})
+
+	It("fails on invalid compression", func() {
+		withConfig("output:\n  gelf:\n    address: udp://graylog:12201\n    compression: lz4", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.gelf.compression must be one of gzip/zlib/none but was lz4"))
+		})
+	})
+
+	It("fails on invalid chunkSize", func() {
+		withConfig("output:\n  gelf:\n    address: udp://graylog:12201\n    chunkSize: 12", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.gelf.chunkSize must be greater than 12 but was 12"))
+		})
+	})
+})
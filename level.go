@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// common spellings of levels, used when levelMap is set, which can extend or override them
+var defaultLevelMap = map[string]string{
+	"trace": "TRACE", "t": "TRACE", "10": "TRACE",
+	"debug": "DEBUG", "d": "DEBUG", "dbg": "DEBUG", "20": "DEBUG",
+	"info": "INFO", "i": "INFO", "information": "INFO", "informational": "INFO", "notice": "INFO", "30": "INFO",
+	"warn": "WARN", "w": "WARN", "warning": "WARN", "40": "WARN",
+	"error": "ERROR", "e": "ERROR", "err": "ERROR", "50": "ERROR",
+	"fatal": "FATAL", "f": "FATAL", "critical": "FATAL", "crit": "FATAL", "panic": "FATAL", "alert": "FATAL", "emerg": "FATAL", "60": "FATAL",
+}
+
+var levelCases = []string{"lower", "upper"}
+
+func (c *Config) setupLevels() error {
+	if c.LevelMap != nil {
+		c.levelMap = map[string]string{}
+		for from, to := range defaultLevelMap {
+			c.levelMap[from] = to
+		}
+		for from, to := range c.LevelMap {
+			c.levelMap[strings.ToLower(from)] = to
+		}
+	}
+
+	if c.LevelCase != "" && !contains(levelCases, c.LevelCase) {
+		return fmt.Errorf("levelCase must be one of %s but was %s", strings.Join(levelCases, "/"), c.LevelCase)
+	}
+
+	if c.MinLevel != "" {
+		if !c.levelKeySet {
+			return fmt.Errorf("minLevel needs levelKey to be set")
+		}
+		severity, found := levelSeverities[strings.ToUpper(c.MinLevel)]
+		if !found {
+			return fmt.Errorf("minLevel must be one of TRACE/DEBUG/INFO/WARN/ERROR/FATAL but was %s", c.MinLevel)
+		}
+		c.minLevel = severity
+	}
+
+	c.normalizeLevelsSet = c.levelKeySet && (c.levelMap != nil || c.LevelCase != "")
+	return nil
+}
+
+// map the level to the canonical spelling and case
+func (c *Config) normalizeLevel(level string) string {
+	if c.levelMap != nil {
+		if mapped, found := c.levelMap[strings.ToLower(level)]; found {
+			level = mapped
+		}
+	}
+	switch c.LevelCase {
+	case "lower":
+		level = strings.ToLower(level)
+	case "upper":
+		level = strings.ToUpper(level)
+	}
+	return level
+}
+
+// below minLevel, unknown levels are never below
+func (c *Config) belowMinLevel(level string) bool {
+	severity, found := levelSeverities[strings.ToUpper(level)]
+	return found && severity < c.minLevel
+}
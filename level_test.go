@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("level", func() {
+	It("normalizes levels from json and captures", func() {
+		withConfig("---\nlevelKey: level\njson: simple\nlevelMap: {Sev3: ERROR}\npatterns:\n- regex: '^(?P<level>\\w+):'", func() {
+			Expect(parse(strings.Join([]string{
+				`{"level":"warning","message":"a"}`,
+				`{"level":30,"message":"b"}`,
+				"sev3: c",
+				"Information: d",
+				"custom: e",
+			}, "\n"))).To(Equal(
+				"{\"level\":\"WARN\",\"message\":\"a\"}\n" +
+					"{\"level\":\"INFO\",\"message\":\"b\"}\n" +
+					"{\"level\":\"ERROR\",\"message\":\"sev3: c\"}\n" +
+					"{\"level\":\"INFO\",\"message\":\"Information: d\"}\n" +
+					"{\"level\":\"custom\",\"message\":\"custom: e\"}",
+			))
+		})
+	})
+
+	It("changes the case of levels", func() {
+		withConfig("---\nlevelKey: level\nlevelCase: lower\nlevelMap: {}\nglog: simple", func() {
+			Expect(parse("W0530 10:13:00.740596      33 foo.go:132] hi")).To(Equal("{\"level\":\"warn\",\"message\":\"hi\"}"))
+		})
+		withConfig("---\nlevelKey: level\nlevelCase: upper\nstreamLevels: {stdout: debug}", func() {
+			Expect(parse("hi")).To(Equal("{\"level\":\"DEBUG\",\"message\":\"hi\"}"))
+		})
+	})
+
+	It("does not write lines below minLevel but counts them", func() {
+		withConfig("---\nlevelKey: level\nminLevel: warn\nlevelCase: lower\nremove: [other]\npatterns:\n- regex: '^(?P<level>\\w+):'", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			config.Otlp = &Otlp{metrics: true, counts: map[string]*otlpCount{}, Labels: config.possibleLabels()}
+			stdout := captureStdout(func() {
+				config.Output.Start()
+				for _, line := range []string{"debug: a", "ERROR: b", "custom: c"} {
+					processLine(StreamLine{line: line}, config)
+				}
+				config.Output.Stop()
+			})
+			Expect(stdout).To(Equal("{\"level\":\"error\",\"message\":\"ERROR: b\"}\n{\"level\":\"custom\",\"message\":\"custom: c\"}\n"))
+			Expect(config.Otlp.counts[`"level"="debug"`].value).To(Equal(uint64(1)))
+		})
+	})
+
+	It("keeps lines without level", func() {
+		withConfig("---\nlevelKey: level\nminLevel: warn\nlevelMap: {}\nremove: [level]", func() {
+			Expect(parse("hi")).To(Equal("{\"message\":\"hi\"}"))
+		})
+	})
+
+	It("fails on invalid level settings", func() {
+		for config, message := range map[string]string{
+			"---\nlevelCase: camel":             "levelCase must be one of lower/upper but was camel",
+			"---\nminLevel: warn":               "minLevel needs levelKey to be set",
+			"---\nlevelKey: level\nminLevel: x": "minLevel must be one of TRACE/DEBUG/INFO/WARN/ERROR/FATAL but was x",
+		} {
+			withConfig(config, func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal(message))
+			})
+		}
+	})
+})
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var lokiBackoff = 100 * time.Millisecond
+
+// pushes logs to loki https://grafana.com/docs/loki/latest/reference/loki-http-api/#ingest-logs
+type Loki struct {
+	Url        string            // for example http://loki:3100
+	Labels     map[string]string // added to the labels of every log
+	BatchSize  int               `yaml:"batchSize"`  // logs per push, default 1000
+	BatchWait  string            `yaml:"batchWait"`  // max time to wait for a batch to fill, default 1s
+	BufferSize int               `yaml:"bufferSize"` // logs to keep in memory while loki is slow, default 10000
+	MaxRetries int               `yaml:"maxRetries"` // retries with backoff before dropping a batch, default 10
+	batchWait  time.Duration
+	labels     map[string]string // possible metric label -> loki label name
+	batcher    batcher
+	batch      []*Entry
+	client     *http.Client
+}
+
+type lokiPush struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (l *Loki) setup() (err error) {
+	if !strings.HasPrefix(l.Url, "http://") && !strings.HasPrefix(l.Url, "https://") {
+		return fmt.Errorf("output.loki.url must start with http:// or https:// but was %s", l.Url)
+	}
+	if l.BatchSize == 0 {
+		l.BatchSize = 1000
+	}
+	if l.BufferSize == 0 {
+		l.BufferSize = 10000
+	}
+	if l.MaxRetries == 0 {
+		l.MaxRetries = 10
+	}
+	if l.batchWait, err = parseDuration(l.BatchWait, time.Second, "output.loki.batchWait"); err != nil {
+		return err
+	}
+	names := keys(l.Labels)
+	sort.Strings(names)
+	for _, name := range names {
+		if !validLabelName(name) {
+			return fmt.Errorf("output.loki.labels must be names like [a-zA-Z_][a-zA-Z0-9_]* but was %s", name)
+		}
+	}
+	return nil
+}
+
+// use the same labels as metrics, so streams stay few and loki does not reject names like user.name
+func (l *Loki) useLabels(possible []string) {
+	l.labels = map[string]string{}
+	for _, label := range possible {
+		l.labels[label] = lokiLabelName(label)
+	}
+}
+
+// loki and prometheus label names must match [a-zA-Z_][a-zA-Z0-9_]*
+func validLabelName(name string) bool {
+	return name != "" && lokiLabelName(name) == name
+}
+
+// loki label names must match [a-zA-Z_][a-zA-Z0-9_]*
+func lokiLabelName(label string) string {
+	name := []byte(label)
+	for i, c := range name {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || i > 0 && c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+func (l *Loki) Name() string {
+	return "loki"
+}
+
+func (l *Loki) Start() {
+	l.client = &http.Client{Timeout: 10 * time.Second}
+	l.batcher.start(l.BufferSize, l.batchWait, l.add, l.push)
+}
+
+func (l *Loki) Stop() {
+	l.batcher.stop()
+}
+
+func (l *Loki) Write(entry *Entry) {
+	l.batcher.write(entry)
+}
+
+func (l *Loki) Dropped() *atomic.Uint64 {
+	return &l.batcher.dropped
+}
+
+func (l *Loki) add(entry *Entry) bool {
+	l.batch = append(l.batch, entry)
+	return len(l.batch) >= l.BatchSize
+}
+
+// push the batch, retrying when loki is down or overloaded
+func (l *Loki) push() {
+	batch := l.batch
+	if len(batch) == 0 {
+		return
+	}
+	l.batch = nil
+	body := l.body(batch)
+	url := strings.TrimRight(l.Url, "/") + "/loki/api/v1/push"
+	headers := map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip"}
+
+	status, _, err := sendWithRetries(l.MaxRetries, lokiBackoff, l.batcher.stopping, func() (int, []byte, error) {
+		return post(l.client, url, headers, body)
+	})
+	if err != nil || status/100 != 2 {
+		l.batcher.dropped.Add(uint64(len(batch)))
+	}
+}
+
+// gzipped json with one stream per unique label set
+func (l *Loki) body(batch []*Entry) []byte {
+	push := lokiPush{}
+	streams := map[string]*lokiStream{}
+	for _, entry := range batch {
+		labels := map[string]string{}
+		for k, v := range l.Labels {
+			labels[k] = v
+		}
+		for k, name := range l.labels {
+			if v := entry.labels[k]; v != "" { // loki ignores empty labels
+				labels[name] = v
+			}
+		}
+
+		key := labelKey(labels)
+		stream, found := streams[key]
+		if !found {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			push.Streams = append(push.Streams, stream)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+	}
+
+	content, err := json.Marshal(push)
+	check(err)
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	_, err = writer.Write(content)
+	check(err)
+	check(writer.Close())
+	return compressed.Bytes()
+}
+
+// unique key for a set of labels
+func labelKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, strconv.Quote(k)+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("loki", func() {
+	var server *httptest.Server
+	var received []string
+	var statuses []int
+	var mutex sync.Mutex
+	var oldBackoff, oldShutdownTimeout time.Duration
+
+	BeforeEach(func() {
+		oldBackoff, oldShutdownTimeout = lokiBackoff, shutdownTimeout
+		lokiBackoff = time.Millisecond
+		received = nil
+		statuses = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/loki/api/v1/push"))
+			Expect(r.Header.Get("Content-Encoding")).To(Equal("gzip"))
+			reader, err := gzip.NewReader(r.Body)
+			Expect(err).To(BeNil())
+			body, err := io.ReadAll(reader)
+			Expect(err).To(BeNil())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			status := http.StatusNoContent
+			if len(statuses) != 0 {
+				status, statuses = statuses[0], statuses[1:]
+			}
+			if status == http.StatusNoContent {
+				received = append(received, string(body))
+			}
+			w.WriteHeader(status)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		lokiBackoff, shutdownTimeout = oldBackoff, oldShutdownTimeout
+	})
+
+	entry := func(line string, labels map[string]string) *Entry {
+		return &Entry{time: time.Unix(1, 2), line: line, labels: labels}
+	}
+
+	start := func(loki *Loki) *Loki {
+		loki.Url = server.URL
+		Expect(loki.setup()).To(BeNil())
+		loki.Start()
+		return loki
+	}
+
+	It("pushes batches grouped by labels", func() {
+		loki := &Loki{Labels: map[string]string{"job": "app"}}
+		loki.useLabels([]string{"level", "empty"})
+		start(loki)
+		loki.Write(entry("a", map[string]string{"level": "INFO", "empty": "", "other": "x"}))
+		loki.Write(entry("b", map[string]string{"level": "WARN"}))
+		loki.Write(entry("c", map[string]string{"level": "INFO"}))
+		loki.Stop()
+		Expect(received).To(Equal([]string{
+			`{"streams":[` +
+				`{"stream":{"job":"app","level":"INFO"},"values":[["1000000002","a"],["1000000002","c"]]},` +
+				`{"stream":{"job":"app","level":"WARN"},"values":[["1000000002","b"]]}]}`,
+		}))
+		Expect(loki.Dropped().Load()).To(Equal(uint64(0)))
+	})
+
+	It("pushes when the batch is full", func() {
+		loki := start(&Loki{BatchSize: 1})
+		loki.Write(entry("a", nil))
+		loki.Write(entry("b", nil))
+		loki.Stop()
+		Expect(received).To(Equal([]string{
+			`{"streams":[{"stream":{},"values":[["1000000002","a"]]}]}`,
+			`{"streams":[{"stream":{},"values":[["1000000002","b"]]}]}`,
+		}))
+	})
+
+	It("pushes when waited long enough", func() {
+		loki := start(&Loki{BatchWait: "10ms"})
+		loki.Write(entry("a", nil))
+		Eventually(func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(received)
+		}).Should(Equal(1))
+		loki.Stop()
+	})
+
+	It("retries when loki is overloaded", func() {
+		statuses = []int{http.StatusInternalServerError, http.StatusTooManyRequests}
+		loki := start(&Loki{})
+		loki.Write(entry("a", nil))
+		loki.Stop()
+		Expect(received).To(Equal([]string{`{"streams":[{"stream":{},"values":[["1000000002","a"]]}]}`}))
+	})
+
+	It("drops when retries are exhausted", func() {
+		statuses = []int{http.StatusInternalServerError, http.StatusInternalServerError}
+		loki := start(&Loki{MaxRetries: 1})
+		loki.Write(entry("a", nil))
+		loki.Write(entry("b", nil))
+		loki.Stop()
+		Expect(received).To(BeNil())
+		Expect(loki.Dropped().Load()).To(Equal(uint64(2)))
+	})
+
+	It("gives up retrying when stopping takes too long", func() {
+		statuses = []int{http.StatusInternalServerError}
+		lokiBackoff = time.Minute
+		shutdownTimeout = 10 * time.Millisecond
+		loki := start(&Loki{})
+		loki.Write(entry("a", nil))
+		started := time.Now()
+		loki.Stop()
+		Expect(time.Since(started)).To(BeNumerically("<", time.Second))
+		Expect(received).To(BeNil())
+		Expect(loki.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("drops when loki rejects", func() {
+		statuses = []int{http.StatusBadRequest}
+		loki := start(&Loki{})
+		loki.Write(entry("a", nil))
+		loki.Stop()
+		Expect(received).To(BeNil())
+		Expect(loki.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("retries when loki is down", func() {
+		loki := &Loki{Url: "http://127.0.0.1:1", MaxRetries: 1}
+		Expect(loki.setup()).To(BeNil())
+		loki.Start()
+		loki.Write(entry("a", nil))
+		loki.Stop()
+		Expect(loki.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("drops when the buffer is full", func() {
+		loki := &Loki{Url: server.URL, BufferSize: 1}
+		Expect(loki.setup()).To(BeNil())
+		loki.batcher.entries = make(chan *Entry, loki.BufferSize) // not started, so nothing is consumed
+		loki.Write(entry("a", nil))
+		loki.Write(entry("b", nil))
+		Expect(loki.Dropped().Load()).To(Equal(uint64(1)))
+	})
+
+	It("backs off exponentially", func() {
+		Expect(backoff(time.Second, 5*time.Second, 0)).To(Equal(time.Second))
+		Expect(backoff(time.Second, 5*time.Second, 2)).To(Equal(4 * time.Second))
+		Expect(backoff(time.Second, 5*time.Second, 3)).To(Equal(5 * time.Second))
+		Expect(backoff(time.Second, 5*time.Second, 100)).To(Equal(5 * time.Second))
+	})
+
+	It("fails on invalid url", func() {
+		withConfig("---\noutput:\n  loki:\n    url: loki:3100", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.loki.url must start with http:// or https:// but was loki:3100"))
+		})
+	})
+
+	It("fails on invalid label names", func() {
+		withConfig("---\noutput:\n  loki:\n    url: http://loki:3100\n    labels:\n      service.name: app", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.loki.labels must be names like [a-zA-Z_][a-zA-Z0-9_]* but was service.name"))
+		})
+	})
+
+	It("fails on invalid batchWait", func() {
+		withConfig("---\noutput:\n  loki:\n    url: http://loki:3100\n    batchWait: 1", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.loki.batchWait must be a positive duration like 1s or 5m but was 1"))
+		})
+	})
+
+	It("pushes metric labels and still writes to stdout", func() {
+		withConfig("---\nlevelKey: level\ntimestampKey: ts\nallowMetricLabels: [level]\noutput:\n  loki:\n    url: "+server.URL+"\npatterns:\n- regex: (?P<name>hi)", func() {
+			Expect(parse("hi")).To(ContainSubstring(`"message":"hi","name":"hi"}`))
+		})
+		Expect(received).To(HaveLen(1))
+		Expect(received[0]).To(MatchRegexp(`^\{"streams":\[\{"stream":\{"level":"INFO"\},"values":\[\["\d+","\{\\"ts\\":\\"[^"]+\\",\\"level\\":\\"INFO\\",\\"message\\":\\"hi\\",\\"name\\":\\"hi\\"\}"\]\]\}\]\}$`))
+	})
+
+	It("only pushes possible metric labels with names loki accepts", func() {
+		withConfig("---\njson: simple\noutput:\n  loki:\n    url: "+server.URL+"\npatterns:\n- regex: hi\n  add: {user.name: bob, 1st: a}", func() {
+			Expect(parse(`{"message":"hi","request_id":"123"}`)).To(ContainSubstring(`"request_id":"123"`))
+		})
+		Expect(received).To(HaveLen(1))
+		Expect(received[0]).To(ContainSubstring(`"stream":{"_st":"a","user_name":"bob"}`))
+	})
+
+	It("pushes uncolored logs that were not written", func() {
+		withConfig("---\noutput:\n  format: console\n  stdout:\n    streams: []\n  loki:\n    url: "+server.URL, func() {
+			Expect(parse("hi")).To(Equal(""))
+		})
+		Expect(received).To(HaveLen(1))
+		Expect(received[0]).To(MatchRegexp(`"values":\[\["\d+","hi"\]\]`))
+	})
+
+	It("reports dropped logs", func() {
+		port := randomPort()
+		withConfig("---\nprometheus:\n  port: "+port+"\noutput:\n  loki:\n    url: "+server.URL, func() {
+			Expect(prometheusMetrics(port)).To(ContainSubstring("logs_dropped_total{output=\"loki\"} 0\n"))
+		})
+	})
+})
@@ -6,25 +6,46 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"math/rand"
 	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const Version = "master" // dynamically set by release action
 
 type StreamLine struct {
-	index int
-	line  string
+	index  int // 0 for stdout and 1 for stderr
+	line   string
+	file   string      // set when coming from a followed file
+	time   time.Time   // set when the input knows when the line was logged
+	level  string      // set when the input knows the level
+	fields *OrderedMap // set when the input knows more about the line
+}
+
+// input that produces lines until stopped
+type Follower interface {
+	Follow(lines chan<- StreamLine)
+	Stop()
 }
 
 func main() {
-	set, command := parseFlags()
+	if len(os.Args) > 1 && os.Args[1] == "suggest" {
+		if err := suggest(os.Args[2:], os.Stdout); err != nil {
+			// untested section
+			_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+			os.Exit(2)
+		}
+		return
+	}
+
+	set, command, files := parseFlags()
+	piping := isPipingToStdin()
 
-	// prevent unsupported dual/no-input usage
-	if isPipingToStdin() == (len(command) != 0) {
+	// prevent unsupported dual input usage
+	if piping && len(command) != 0 {
 		// untested section
 		set.Usage()
 		os.Exit(2)
@@ -36,8 +57,29 @@ func main() {
 		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
 		os.Exit(2)
 	}
+	for _, file := range files {
+		config.Inputs = append(config.Inputs, Input{File: file})
+	}
+
+	followers, err := buildFollowers(config)
+	if err != nil {
+		// untested section
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %v\n", err.Error())
+		os.Exit(2)
+	}
+
+	// prevent unsupported no input usage
+	if !piping && len(command) == 0 && len(followers) == 0 {
+		// untested section
+		set.Usage()
+		os.Exit(2)
+	}
 
 	if config.Prometheus != nil {
+		config.Prometheus.Labels = config.possibleLabels()
+		config.Prometheus.Outputs = config.Output.droppers()
+		config.Prometheus.Templates = config.Templates
+		config.Prometheus.Constants = config.constLabels()
 		config.Prometheus.Start()
 		defer config.Prometheus.Stop()
 	}
@@ -47,26 +89,64 @@ func main() {
 		defer config.Statsd.Stop()
 	}
 
+	if config.Otlp != nil {
+		config.Otlp.Labels = config.possibleLabels()
+		config.Otlp.Outputs = config.Output.droppers()
+		config.Otlp.Start()
+	}
+
+	if config.Output.Loki != nil {
+		config.Output.Loki.useLabels(config.possibleLabels())
+	}
+
+	config.Output.Start()
+	for _, sink := range config.Output.sinks {
+		sink.Start()
+	}
+	stopReopening := reopenOnHangup(config.Output.sinks, config.reopeners()...)
+	defer stopReopening()
+	stopSummaries := startSummaries(config)
+
 	var streams []io.Reader
 	var exit chan (int)
 
 	if len(command) != 0 {
 		// read from command
-		streams, exit, err = executeCommand(command)
+		var pid int
+		streams, exit, pid, err = executeCommand(command)
 		if err != nil {
 			// untested section
 			_, _ = fmt.Fprintln(os.Stderr, err.Error())
 			os.Exit(2)
 		}
-	} else {
+		config.setChildPid(pid)
+	} else if piping {
 		// read from stdin
 		streams = []io.Reader{os.Stdin}
 	}
 
+	if len(streams) == 0 {
+		stopOnSignal(followers) // untested section
+	}
+
 	// process the stream line by line
-	lines := combineStreams(streams)
-	for l := range lines {
-		processLine(l, config)
+	lines := combineStreams(streams, followers, config.Format)
+	if config.Workers > 1 {
+		processParallel(lines, config)
+	} else {
+		for l := range lines {
+			processLine(l, config)
+		}
+	}
+
+	// deliver everything before we exit
+	stopSummaries()
+	config.Output.Stop()
+	for _, sink := range config.Output.sinks {
+		sink.Stop()
+	}
+	if config.Otlp != nil {
+		config.Otlp.Stop()
 	}
 
 	// exit with the exit code of the command
@@ -79,7 +159,29 @@ func main() {
 	}
 }
 
-func combineStreams(streams []io.Reader) chan StreamLine {
+// files and servers from inputs
+func buildFollowers(config *Config) ([]Follower, error) {
+	followers := []Follower{}
+
+	if files := config.files(); len(files) != 0 {
+		followers = append(followers, NewTailer(files, config.Positions))
+	}
+
+	for _, input := range config.Inputs {
+		if input.Syslog != "" {
+			server, err := NewSyslogServer(input.Syslog)
+			if err != nil {
+				return nil, err
+			}
+			followers = append(followers, server)
+		}
+	}
+
+	return followers, nil
+}
+
+// read all streams and followers, followers are stopped when all streams are done
+func combineStreams(streams []io.Reader, followers []Follower, format string) chan StreamLine {
 	lines := make(chan StreamLine)
 
 	var wg sync.WaitGroup
@@ -87,24 +189,85 @@ func combineStreams(streams []io.Reader) chan StreamLine {
 		wg.Add(1)
 		go func(idx int, r io.Reader) {
 			defer wg.Done()
+			decoder := NewDecoder(format)
 			scanner := bufio.NewScanner(r)
 			for scanner.Scan() {
-				lines <- StreamLine{idx, scanner.Text()}
+				if line, complete := decoder.Decode(StreamLine{index: idx, line: scanner.Text()}); complete {
+					lines <- line
+				}
 			}
 		}(i, stream)
 	}
 
+	var following sync.WaitGroup
+	for _, follower := range followers {
+		following.Add(1)
+		go func(f Follower) {
+			defer following.Done()
+			f.Follow(lines)
+		}(follower)
+	}
+
 	go func() {
 		wg.Wait()
+		if len(streams) != 0 {
+			stopAll(followers)
+		}
+		following.Wait()
 		close(lines)
 	}()
 
 	return lines
 }
 
+// stop following when asked to stop since there is nothing else that would end the program
+func stopOnSignal(followers []Follower) {
+	// untested section
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		// untested section
+		<-signals
+		stopAll(followers)
+	}()
+}
+
+// reopen files on SIGHUP like logrotate expects, the wrapped command still gets the signal forwarded
+// returns a function to stop reopening
+func reopenOnHangup(sinks []Sink, reopeners ...reopener) (stop func()) {
+	for _, sink := range sinks {
+		if r, ok := sink.(reopener); ok {
+			reopeners = append(reopeners, r)
+		}
+	}
+	if len(reopeners) == 0 {
+		return func() {} // keep the default of exiting on SIGHUP
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			for _, r := range reopeners {
+				r.Reopen()
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+	}
+}
+
+func stopAll(followers []Follower) {
+	for _, follower := range followers {
+		follower.Stop()
+	}
+}
+
 // parse flags ... so we fail on unknown flags and users can call `-help`
 // TODO: return errors so we can test this method
-func parseFlags() (*flag.FlagSet, []string) {
+func parseFlags() (*flag.FlagSet, []string, []string) {
 	programName, args := os.Args[0], os.Args[1:]
 	args, command := splitArrayOn(args, "--")
 
@@ -116,6 +279,8 @@ func parseFlags() (*flag.FlagSet, []string) {
 			"logrecycler "+Version+"\n"+
 				"pipe logs to logrecycler to convert them into json logs with custom tags\n"+
 				"alternatively tell it what command to execute with `-- command`\n"+
+				"and/or what files to follow with `-file glob`\n"+
+				"draft patterns from a sample log with `logrecycler suggest sample.log`\n"+
 				"configure with logrecycler.yaml\n"+
 				"for more info see https://github.com/grosser/logrecycler\n",
 		)
@@ -123,6 +288,8 @@ func parseFlags() (*flag.FlagSet, []string) {
 	}
 	version := set.Bool("version", false, "Show version")
 	help := set.Bool("help", false, "Show this")
+	var files stringList
+	set.Var(&files, "file", "Follow files matching this `glob` like tail -F (can be used multiple times)")
 
 	if err := set.Parse(args); err != nil { // untested section
 		set.Usage()
@@ -144,20 +311,71 @@ func parseFlags() (*flag.FlagSet, []string) {
 		os.Exit(2)
 	}
 
-	return set, command
+	return set, command, files
+}
+
+// a line that went through all the regex work and only needs to be written and reported
+type processedLine struct {
+	out        *asyncWriter // nil when it should not be written
+	serialized string
+	entry      *Entry // nil when there are no sinks
+	labels     map[string]string
+	log        *OrderedMap
+	stream     string
+	time       time.Time
+	dedupeKey  string      // empty when it should not be deduped
+	full       *OrderedMap // copy of the log before it was stripped to labels, when deduping
 }
 
-// everything in here needs to be extra efficient
 func processLine(line StreamLine, config *Config) {
+	if processed := prepareLine(line, config); processed != nil {
+		emitLine(processed, config)
+		processed.release()
+	}
+}
+
+// reuse the log for the next line, unless sinks still need its labels
+func (p *processedLine) release() {
+	if p.entry == nil {
+		p.log.Release()
+	}
+}
+
+// everything in here needs to be extra efficient, returns nil when the line was discarded
+// can run in parallel since it only reads the config
+func prepareLine(line StreamLine, config *Config) *processedLine {
 	// build log line ... sets the json key order too
-	log := NewOrderedMap()
+	log := AcquireOrderedMap()
+	timestamp := line.time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
 	if config.timestampKeySet {
-		log.Set(config.TimestampKey, time.Now().Format(timeFormat))
+		log.Set(config.TimestampKey, timestamp.Format(timeFormat))
 	}
+	stream := streamNames[line.index]
 	if config.levelKeySet {
-		log.Set(config.LevelKey, "INFO")
+		if line.level != "" {
+			log.Set(config.LevelKey, line.level)
+		} else if level, found := config.StreamLevels[stream]; found {
+			log.Set(config.LevelKey, level)
+		} else {
+			log.Set(config.LevelKey, "INFO")
+		}
+	}
+	for _, field := range config.fields {
+		log.Set(field.key, field.value)
 	}
 	log.Set(config.MessageKey, line.line)
+	if config.streamKeySet {
+		log.Set(config.StreamKey, stream)
+	}
+	if line.file != "" {
+		log.Set(fileKey, line.file)
+	}
+	if line.fields != nil {
+		log.MergeOrdered(line.fields)
+	}
 
 	// preprocess the log line for general purpose cleanup
 	if config.preprocessSet {
@@ -182,18 +400,28 @@ func processLine(line StreamLine, config *Config) {
 		}
 	}
 
+	// rename/remove/copy fields before patterns so they can use them
+	config.FieldOperations.apply(log)
+
 	// apply pattern rules if any
 	var ignoreMetricLabels []string
-	for _, pattern := range config.Patterns {
+	var rateLimit *RateLimit
+	countOnly := false // sampled out or rate limited, but still reported to metrics
+	var candidates bitset
+	if config.patternFilter != nil {
+		candidates = config.patternFilter.candidates(log.values[config.MessageKey])
+	}
+	unknown := true // nothing or only the catch-all matched
+	for i, pattern := range config.Patterns {
+		if candidates != nil && !candidates.has(i) {
+			continue // cannot match
+		}
 		if match := pattern.regexParsed.FindStringSubmatch(log.values[config.MessageKey]); match != nil {
-			if pattern.Discard {
-				return
-			}
+			unknown = pattern.Regex == ""
 
-			if pattern.SampleRate != nil {
-				if rand.Float32() > *pattern.SampleRate {
-					return
-				}
+			if pattern.Discard {
+				log.Release()
+				return nil
 			}
 
 			// set level
@@ -202,49 +430,245 @@ func processLine(line StreamLine, config *Config) {
 			}
 
 			log.StoreNamedCaptures(pattern.regexParsed, &match)
-			log.Merge(pattern.Add)
+			log.Merge(pattern.addStatic)
+			renderAdd(log, pattern.addTemplates)
+			pattern.FieldOperations.apply(log)
+			applyConversions(log, pattern.convert)
 
 			ignoreMetricLabels = pattern.IgnoreMetricLabels
 
+			// sample after captures so we can sample by them
+			if pattern.SampleRate != nil {
+				if config.sampler.keep(&config.Patterns[i], log.values) {
+					if config.sampleKeySet {
+						log.SetRaw(config.SampleKey, pattern.sampleWeight) // a number so it can be summed
+					}
+				} else if pattern.CountSampled {
+					countOnly = true
+				} else {
+					log.Release()
+					return nil
+				}
+			}
+
+			rateLimit = pattern.RateLimit
+
 			break // a line can only match one pattern
 		}
 	}
 
-	// write to where the line came from
-	out := os.Stdout
-	if line.index == 1 {
-		out = os.Stderr
+	// look up details like the team of an error code
+	var enriched []string // keys to keep out of metrics
+	for i := range config.Enrich {
+		enriched = config.Enrich[i].apply(log, enriched)
+	}
+
+	// one spelling for levels from json, captures and patterns, so routes, metrics and minLevel work
+	if config.normalizeLevelsSet {
+		if level, found := log.values[config.LevelKey]; found {
+			log.values[config.LevelKey] = config.normalizeLevel(level)
+		}
+	}
+	if config.MinLevel != "" && config.belowMinLevel(log.values[config.LevelKey]) {
+		countOnly = true
+	}
+
+	// after enrich and level normalization so summaries look like the lines they stand for
+	if rateLimit != nil && !countOnly && !rateLimit.allow(log, enriched, line, time.Now()) {
+		countOnly = true
+	}
+
+	// group unknown lines so new patterns can be written for the most frequent
+	if config.Templates != nil && unknown {
+		if id := config.Templates.add(log.values[config.MessageKey]); id != "" {
+			log.Set(config.Templates.Key, id)
+		}
+	}
+
+	// serialize for where the line came from or where it was routed to
+	var out *asyncWriter
+	if !countOnly {
+		out = config.Output.destination(stream, log.values[config.LevelKey])
+	}
+	var serialized string
+	if out != nil {
+		serialized = config.Output.serialize(log, config, out)
+	}
+
+	// sinks get everything, so copy it before we strip it down to metric labels
+	var entry *Entry
+	if config.Output.sinks != nil && !countOnly {
+		if out == nil || config.Output.Format == "console" { // nothing to reuse or colored
+			serialized = config.Output.serialize(log, config, nil)
+		}
+		entry = &Entry{time: timestamp, level: log.values[config.LevelKey], log: log.Copy(), line: serialized}
+	}
+
+	// remember everything for the summary in case the line is the first of a run
+	var key string
+	var full *OrderedMap
+	if config.Dedupe != nil && !countOnly {
+		timestampKey := ""
+		if config.timestampKeySet {
+			timestampKey = config.TimestampKey
+		}
+		key = dedupeKey(stream, log, timestampKey)
+		full = log.Copy() // not the copy of the entry since sinks might still read that while the summary is built
+	}
+
+	log.values = metricLabels(log.values, config, ignoreMetricLabels, line.fields != nil)
+	for _, key := range enriched {
+		delete(log.values, key)
+	}
+	if entry != nil {
+		entry.labels = log.values
+	}
+
+	return &processedLine{
+		out:        out,
+		serialized: serialized,
+		entry:      entry,
+		labels:     log.values,
+		log:        log,
+		stream:     stream,
+		time:       timestamp,
+		dedupeKey:  key,
+		full:       full,
+	}
+}
+
+// how often to check for rate limit bursts or duplicate runs that need a summary
+var summaryInterval = time.Second
+
+// write summaries periodically until the returned function is called, which writes the remaining summaries
+func startSummaries(config *Config) (stop func()) {
+	limited := false
+	for _, pattern := range config.Patterns {
+		limited = limited || pattern.RateLimit != nil
+	}
+	if !limited && config.Dedupe == nil {
+		return func() {}
+	}
+
+	write := func(all bool) {
+		if limited {
+			writeRateLimitSummaries(config, all)
+		}
+		if config.Dedupe != nil {
+			writeDedupeSummaries(config, all)
+		}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(summaryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				write(false)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+		write(true)
+	}
+}
+
+// write a line that summarizes others to outputs and sinks, but not to metrics since the others were already counted
+func writeSummary(config *Config, log *OrderedMap, stream string, at time.Time, labels map[string]string) {
+	out := config.Output.destination(stream, log.values[config.LevelKey])
+	serialized := ""
+	if out != nil {
+		serialized = config.Output.serialize(log, config, out)
+		out.write(serialized)
+	}
+	if config.Output.sinks != nil {
+		if out == nil || config.Output.Format == "console" {
+			serialized = config.Output.serialize(log, config, nil)
+		}
+		entry := &Entry{time: at, level: log.values[config.LevelKey], log: log, line: serialized, labels: labels}
+		for _, sink := range config.Output.sinks {
+			sink.Write(entry)
+		}
 	}
-	_, _ = fmt.Fprintln(out, log.ToJson())
+}
 
+// strip values down to what metrics are labeled with, reusing the map when possible
+func metricLabels(values map[string]string, config *Config, ignoreMetricLabels []string, fromInput bool) map[string]string {
 	// remove keys nobody should be using as metrics, but can get set accidentally via captures
-	delete(log.values, config.MessageKey)
+	delete(values, config.MessageKey)
 	if config.timestampKeySet {
-		delete(log.values, config.TimestampKey)
+		delete(values, config.TimestampKey)
+	}
+	if config.sampleKeySet {
+		delete(values, config.SampleKey) // would split counts of kept and sampled out lines
+	}
+	if fromInput {
+		delete(values, syslogProcidKey) // changes with every restart
+	}
+	if config.Templates != nil {
+		delete(values, config.Templates.Key) // too many to be a label
+	}
+	if config.Prometheus != nil {
+		config.removeConstLabels(values)
 	}
 
 	// remove not explicitly allowed labels
 	if config.AllowMetricLabels != nil {
-		previous := log.values
-		log.values = map[string]string{}
+		previous := values
+		values = map[string]string{}
 		for _, l := range config.AllowMetricLabels {
 			if previousValue, previousSet := previous[l]; previousSet {
-				log.values[l] = previousValue
+				values[l] = previousValue
 			}
 		}
 	}
 
 	// remove explicitly ignored labels
 	for _, l := range ignoreMetricLabels {
-		delete(log.values, l)
+		delete(values, l)
+	}
+	return values
+}
+
+// write and report a prepared line, must be called in the order lines were read
+func emitLine(processed *processedLine, config *Config) {
+	duplicate := false
+	if processed.dedupeKey != "" {
+		var ended *dedupeRun
+		duplicate, ended = config.Dedupe.track(processed, time.Now())
+		if ended != nil {
+			writeDedupeSummary(config, ended)
+		}
+	}
+
+	if processed.out != nil && !duplicate {
+		processed.out.write(processed.serialized)
+	}
+
+	if processed.entry != nil && !duplicate {
+		for _, sink := range config.Output.sinks {
+			sink.Write(processed.entry)
+		}
 	}
 
 	// report to metrics backends
 	if config.Prometheus != nil {
-		config.Prometheus.Inc(log.values)
+		config.Prometheus.Inc(processed.labels)
 	}
 	if config.Statsd != nil {
-		config.Statsd.Inc(log.values)
+		config.Statsd.Inc(processed.labels)
+	}
+	if config.Otlp != nil {
+		config.Otlp.Inc(processed.labels)
 	}
 }
 
@@ -261,8 +685,22 @@ func captureJson(config *Config, log *OrderedMap) {
 	log.values[config.MessageKey] = ""
 
 	for k, v := range jsonMap {
-		// TODO: allow parsing through any json type by not using Sprintf
-		log.Set(k, fmt.Sprintf("%v", v))
+		log.Set(k, jsonString(v))
+	}
+}
+
+// json value as string, formatted like go does
+func jsonString(v any) string {
+	// TODO: allow parsing through any json type by not using Sprintf
+	switch value := v.(type) {
+	case string: // avoid Sprintf for common types
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return fmt.Sprintf("%v", v)
 	}
 }
 
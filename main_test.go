@@ -11,6 +11,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -129,6 +131,27 @@ var _ = Describe("main", func() {
 		})
 	})
 
+	It("forwards every signal to the command while reopening on SIGHUP", func() {
+		dir, err := os.MkdirTemp("", "logrecycler")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		withConfig("---\noutput:\n  file:\n    path: "+dir+"/log.json", func() {
+			// SIGUSR1 instead of SIGTERM to end the command since ginkgo aborts the suite on SIGTERM
+			output, wait := runInBackground("--", "sh", "-c", "trap 'echo hup' HUP; trap 'echo usr1; exit 0' USR1; echo ready; while true; do sleep 0.01; done")
+			expected := "{\"message\":\"ready\"}\n"
+			for _, s := range []struct {
+				signal  syscall.Signal
+				message string
+			}{{syscall.SIGHUP, "hup"}, {syscall.SIGHUP, "hup"}, {syscall.SIGUSR1, "usr1"}} {
+				Eventually(output).Should(Equal(expected)) // the command and handlers are ready
+				Expect(syscall.Kill(os.Getpid(), s.signal)).To(BeNil())
+				expected += "{\"message\":\"" + s.message + "\"}\n"
+			}
+			wait()
+			Expect(output()).To(Equal(expected))
+		})
+	})
+
 	It("can parse empty lines", func() {
 		withConfig("", func() {
 			Expect(parse("\n")).To(Equal(`{"message":""}`))
@@ -179,6 +202,14 @@ var _ = Describe("main", func() {
 			})
 		})
 
+		It("formats non-strings like go does", func() {
+			withConfig("---\njson: simple", func() {
+				for input, value := range map[string]string{"1.5e21": "1.5e+21", "0.25": "0.25", "true": "true", "null": `\u003cnil\u003e`, `[1,"a"]`: "[1 a]"} {
+					Expect(parse(`{"foo":` + input + `}`)).To(Equal(`{"message":"","foo":"` + value + `"}`))
+				}
+			})
+		})
+
 		It("ignores invalid", func() {
 			withConfig("---\njson: simple", func() {
 				Expect(parse("{}}}")).
@@ -325,9 +356,15 @@ var _ = Describe("main", func() {
 	})
 })
 
-// ports are not freed fast enough when running on travis, so instead of waiting use a random port
+// a port nothing is listening on, so servers under test do not collide with other services
 func randomPort() string {
-	return strconv.Itoa(rand.Intn(5000) + 1000)
+	for {
+		port := strconv.Itoa(rand.Intn(5000) + 1000)
+		if listener, err := net.Listen("tcp", "0.0.0.0:"+port); err == nil {
+			listener.Close()
+			return port
+		}
+	}
 }
 
 func parse(input string) (output string) {
@@ -338,22 +375,77 @@ func parse(input string) (output string) {
 	return
 }
 
-func runWithCommand(input ...string) (out string) {
+func runWithCommand(input ...string) string {
+	return runWithArgs(append([]string{"--"}, input...)...)
+}
+
+func runWithArgs(args ...string) (out string) {
 	before := os.Args
-	os.Args = append([]string{"foo", "--"}, input...)
+	os.Args = append([]string{"foo"}, args...)
 	defer func() { os.Args = before }()
 	out = captureStdout(func() { main() })
 	out = strings.TrimRight(out, "\n")
 	return
 }
 
+// run main while the test interacts with it, returns what was written to stdout so far and a function to wait for main to finish
+func runInBackground(args ...string) (output func() string, wait func()) {
+	before := os.Args
+	os.Args = append([]string{"foo"}, args...)
+	old := os.Stdout
+	r, w, _ := os.Pipe()
+	os.Stdout = w
+
+	var mutex sync.Mutex
+	var buf bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		chunk := make([]byte, 1024)
+		for {
+			n, err := r.Read(chunk)
+			mutex.Lock()
+			buf.Write(chunk[:n])
+			mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		main()
+		close(done)
+	}()
+
+	output = func() string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return buf.String()
+	}
+	wait = func() {
+		<-done
+		w.Close()
+		<-copied
+		os.Stdout = old
+		os.Args = before
+	}
+	return
+}
+
 func prometheusMetrics(port string) string {
 	out := "ERROR"
+	done := make(chan struct{})
 	withStdin("hi\n", true, func() {
-		go captureStdout(func() { main() }) // finished when stdin closes
-		time.Sleep(10 * time.Millisecond)   // works locally without, but travis needs it
+		go func() {
+			captureStdout(func() { main() }) // finished when stdin closes
+			close(done)
+		}()
+		time.Sleep(10 * time.Millisecond) // works locally without, but travis needs it
 		out = request("http://0.0.0.0:" + port + "/metrics")
 	})
+	<-done // so stdout is restored before the next test captures it
 	return out
 }
 
@@ -1,19 +1,53 @@
 package main
 
 import (
-	"encoding/json"
+	"maps"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
+const (
+	colorReset = "\033[0m"
+	colorGray  = "\033[90m"
+)
+
+var levelColors = map[string]string{
+	"TRACE": colorGray,
+	"DEBUG": colorGray,
+	"INFO":  "\033[32m",
+	"WARN":  "\033[33m",
+	"ERROR": "\033[31m",
+	"FATAL": "\033[31m",
+}
+
 // minimal & fast ordered map implementation since go does not offer it
 type OrderedMap struct {
 	keys   []string
 	values map[string]string
+	raw    map[string]bool // values that are already json like numbers, nil until there are any
 }
 
+// sized for the usual timestamp/level/message + a few captures to avoid growing
 func NewOrderedMap() *OrderedMap {
-	return &OrderedMap{keys: []string{}, values: map[string]string{}}
+	return &OrderedMap{keys: make([]string, 0, 8), values: make(map[string]string, 8)}
+}
+
+// reused between lines so processing does not allocate new maps for every line
+var orderedMapPool = sync.Pool{New: func() any { return NewOrderedMap() }}
+
+// empty map from the pool, Release it when nothing references it or its values map anymore
+func AcquireOrderedMap() *OrderedMap {
+	return orderedMapPool.Get().(*OrderedMap)
+}
+
+func (m *OrderedMap) Release() {
+	m.keys = m.keys[:0]
+	clear(m.values)
+	clear(m.raw)
+	orderedMapPool.Put(m)
 }
 
 func (m *OrderedMap) Set(key string, value string) {
@@ -21,6 +55,70 @@ func (m *OrderedMap) Set(key string, value string) {
 		m.keys = append(m.keys, key)
 	}
 	m.values[key] = value
+	if m.raw != nil {
+		delete(m.raw, key)
+	}
+}
+
+// value is written to json without quotes, so it needs to be a valid json number or boolean
+func (m *OrderedMap) SetRaw(key string, value string) {
+	m.Set(key, value)
+	if m.raw == nil {
+		m.raw = map[string]bool{}
+	}
+	m.raw[key] = true
+}
+
+func (m *OrderedMap) Delete(key string) {
+	if _, exists := m.values[key]; !exists {
+		return
+	}
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+	delete(m.values, key)
+	delete(m.raw, key)
+}
+
+// move the value to a new key at the same position, or to the position of `to` if it already exists
+func (m *OrderedMap) Rename(from string, to string) {
+	value, exists := m.values[from]
+	if !exists || from == to {
+		return
+	}
+	raw := m.raw[from]
+	if _, found := m.values[to]; found {
+		m.Delete(from)
+	} else {
+		for i, k := range m.keys {
+			if k == from {
+				m.keys[i] = to
+				break
+			}
+		}
+		delete(m.values, from)
+		delete(m.raw, from)
+		m.values[to] = value // keeps the position when setting
+	}
+	m.setValue(to, value, raw)
+}
+
+// copy the value to another key, keeping it a json value if it was one
+func (m *OrderedMap) CopyKey(from string, to string) {
+	if value, exists := m.values[from]; exists {
+		m.setValue(to, value, m.raw[from])
+	}
+}
+
+func (m *OrderedMap) setValue(key string, value string, raw bool) {
+	if raw {
+		m.SetRaw(key, value)
+	} else {
+		m.Set(key, value)
+	}
 }
 
 func (m *OrderedMap) Merge(add map[string]string) {
@@ -29,6 +127,24 @@ func (m *OrderedMap) Merge(add map[string]string) {
 	}
 }
 
+func (m *OrderedMap) MergeOrdered(add *OrderedMap) {
+	for _, k := range add.keys {
+		m.Set(k, add.values[k])
+	}
+}
+
+func (m *OrderedMap) Copy() *OrderedMap {
+	copied := &OrderedMap{keys: make([]string, len(m.keys)), values: make(map[string]string, len(m.values))}
+	copy(copied.keys, m.keys)
+	for k, v := range m.values {
+		copied.values[k] = v
+	}
+	if len(m.raw) != 0 {
+		copied.raw = maps.Clone(m.raw)
+	}
+	return copied
+}
+
 // more efficient than creating a new map and merging it
 func (m *OrderedMap) StoreNamedCaptures(re *regexp.Regexp, match *[]string) {
 	for i, name := range re.SubexpNames() {
@@ -43,19 +159,137 @@ func (m *OrderedMap) StoreNamedCaptures(re *regexp.Regexp, match *[]string) {
 // https://github.com/golang/go/issues/27179
 // https://stackoverflow.com/questions/25182923/serialize-a-map-using-a-specific-order
 func (m *OrderedMap) ToJson() string {
+	size := 2
+	for _, key := range m.keys {
+		size += len(key) + len(m.values[key]) + 6
+	}
+	var b strings.Builder
+	b.Grow(size)
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		writeJsonString(&b, key)
+		b.WriteByte(':')
+		if m.raw[key] {
+			b.WriteString(m.values[key])
+		} else {
+			writeJsonString(&b, m.values[key])
+		}
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// key=value pairs, quoting values that would otherwise be ambiguous
+// https://brandur.org/logfmt
+func (m *OrderedMap) ToLogfmt() string {
 	items := make([]string, len(m.keys))
 	for i, key := range m.keys {
-		items[i] = m.marshalValue(key) + ":" + m.marshalValue(m.values[key])
+		items[i] = logfmtKey(key) + "=" + logfmtValue(m.values[key])
 	}
-	return "{" + strings.Join(items, ",") + "}"
+	return strings.Join(items, " ")
 }
 
-func (m *OrderedMap) marshalValue(value string) string {
-	bytes, err := json.Marshal(value)
-	if err == nil {
-		value = string(bytes)
-	} else {
-		value = "\"logrecycler error in json.Marshal\"" // untested section
+// human-readable `timestamp LEVEL message key=value`, skipping empty timestamp/level/message keys
+func (m *OrderedMap) ToConsole(timestampKey string, levelKey string, messageKey string, color bool) string {
+	items := []string{}
+	if timestampKey != "" {
+		items = append(items, m.values[timestampKey])
+	}
+	if levelKey != "" {
+		level := m.values[levelKey]
+		if color {
+			if c, found := levelColors[strings.ToUpper(level)]; found {
+				level = c + level + colorReset
+			}
+		}
+		items = append(items, level)
+	}
+	items = append(items, m.values[messageKey])
+
+	for _, key := range m.keys {
+		if key != timestampKey && key != levelKey && key != messageKey {
+			pair := logfmtKey(key) + "=" + logfmtValue(m.values[key])
+			if color {
+				pair = colorGray + logfmtKey(key) + "=" + colorReset + logfmtValue(m.values[key])
+			}
+			items = append(items, pair)
+		}
+	}
+	return strings.Join(items, " ")
+}
+
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func logfmtValue(value string) string {
+	if value == "" || strings.IndexFunc(value, func(r rune) bool { return r <= ' ' || r == '=' || r == '"' || r == '\\' }) != -1 {
+		return strconv.Quote(value)
 	}
 	return value
 }
+
+const hexDigits = "0123456789abcdef"
+
+// escapes like json.Marshal (including <>& for html) without its reflection and allocations
+func writeJsonString(b *strings.Builder, value string) {
+	b.WriteByte('"')
+	start := 0 // of what was not written yet
+	for i := 0; i < len(value); {
+		c := value[i]
+		if c < utf8.RuneSelf {
+			if c >= ' ' && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b.WriteString(value[start:i])
+			switch c {
+			case '"', '\\':
+				b.WriteByte('\\')
+				b.WriteByte(c)
+			case '\b':
+				b.WriteString(`\b`)
+			case '\f':
+				b.WriteString(`\f`)
+			case '\n':
+				b.WriteString(`\n`)
+			case '\r':
+				b.WriteString(`\r`)
+			case '\t':
+				b.WriteString(`\t`)
+			default:
+				b.WriteString(`\u00`)
+				b.WriteByte(hexDigits[c>>4])
+				b.WriteByte(hexDigits[c&0xf])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(value[i:])
+		if r == utf8.RuneError && size == 1 { // invalid utf8
+			b.WriteString(value[start:i])
+			b.WriteRune(utf8.RuneError)
+		} else if r == '\u2028' || r == '\u2029' { // breaks javascript
+			b.WriteString(value[start:i])
+			b.WriteString(`\u202`)
+			b.WriteByte(hexDigits[r&0xf])
+		} else {
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	b.WriteString(value[start:])
+	b.WriteByte('"')
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var otlpBackoff = 100 * time.Millisecond
+
+var otlpSignals = []string{"logs", "metrics"}
+
+// exports logs and metrics to an opentelemetry collector with json over http
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+type Otlp struct {
+	Endpoint     string            // for example http://otel-collector:4318
+	Headers      map[string]string // sent with every request, for example for authentication
+	Resource     map[string]string // resource attributes, for example service.name
+	Signals      []string          // what to export, default logs and metrics
+	Interval     string            // how often to export metrics, default 10s
+	BatchSize    int               `yaml:"batchSize"`  // logs per request, default 1000
+	BatchWait    string            `yaml:"batchWait"`  // max time to wait for a batch to fill, default 1s
+	BufferSize   int               `yaml:"bufferSize"` // logs to keep in memory while the collector is slow, default 10000
+	MaxRetries   int               `yaml:"maxRetries"` // retries with backoff before dropping a request, default 10
+	Outputs      []dropper         `yaml:"-"`          // to export how many logs they dropped
+	Labels       []string          `yaml:"-"`          // to export, everything else would create a series per unique value
+	interval     time.Duration
+	batchWait    time.Duration
+	logs         *otlpLogs
+	metrics      bool
+	counts       map[string]*otlpCount
+	mutex        sync.Mutex
+	started      time.Time
+	stop         chan struct{}
+	stopping     chan struct{} // closed when the final export takes too long so retries give up
+	done         chan struct{}
+	client       *http.Client
+	timestampKey string
+	levelKey     string
+	messageKey   string
+}
+
+// sink that exports every log as a LogRecord
+type otlpLogs struct {
+	otlp    *Otlp
+	batcher batcher
+	batch   []*Entry
+}
+
+type otlpCount struct {
+	labels map[string]string
+	value  uint64
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano   string         `json:"timeUnixNano"`
+	SeverityNumber int            `json:"severityNumber,omitempty"`
+	SeverityText   string         `json:"severityText,omitempty"`
+	Body           otlpAnyValue   `json:"body"`
+	Attributes     []otlpKeyValue `json:"attributes"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Sum         otlpSum `json:"sum"`
+}
+
+type otlpSum struct {
+	AggregationTemporality int               `json:"aggregationTemporality"` // 2 = cumulative
+	IsMonotonic            bool              `json:"isMonotonic"`
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsInt             string         `json:"asInt"`
+}
+
+func (o *Otlp) setup(config *Config) (err error) {
+	if !strings.HasPrefix(o.Endpoint, "http://") && !strings.HasPrefix(o.Endpoint, "https://") {
+		return fmt.Errorf("otlp.endpoint must start with http:// or https:// but was %s", o.Endpoint)
+	}
+	if o.Signals == nil {
+		o.Signals = otlpSignals
+	}
+	for _, signal := range o.Signals {
+		if !contains(otlpSignals, signal) {
+			return fmt.Errorf("otlp.signals must be logs or metrics but was %s", signal)
+		}
+	}
+	if o.BatchSize == 0 {
+		o.BatchSize = 1000
+	}
+	if o.BufferSize == 0 {
+		o.BufferSize = 10000
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = 10
+	}
+	if o.interval, err = parseDuration(o.Interval, 10*time.Second, "otlp.interval"); err != nil {
+		return err
+	}
+	if o.batchWait, err = parseDuration(o.BatchWait, time.Second, "otlp.batchWait"); err != nil {
+		return err
+	}
+
+	o.timestampKey = config.TimestampKey
+	o.levelKey = config.LevelKey
+	o.messageKey = config.MessageKey
+	o.client = &http.Client{Timeout: 10 * time.Second}
+	o.metrics = contains(o.Signals, "metrics")
+	if contains(o.Signals, "logs") {
+		o.logs = &otlpLogs{otlp: o}
+	}
+	return nil
+}
+
+// export metrics periodically, logs are exported by the logs sink
+func (o *Otlp) Start() {
+	o.started = time.Now()
+	o.counts = map[string]*otlpCount{}
+	o.stop = make(chan struct{})
+	o.stopping = make(chan struct{})
+	o.done = make(chan struct{})
+	if !o.metrics {
+		close(o.done)
+		return
+	}
+
+	go func() {
+		defer close(o.done)
+		ticker := time.NewTicker(o.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-o.stop:
+				o.exportMetrics()
+				return
+			case <-ticker.C:
+				o.exportMetrics()
+			}
+		}
+	}()
+}
+
+// export the final metrics, call after outputs are stopped so their drops are included
+func (o *Otlp) Stop() {
+	close(o.stop)
+	awaitShutdown(o.done, o.stopping)
+}
+
+func (o *Otlp) Inc(values map[string]string) {
+	if !o.metrics {
+		return
+	}
+	labels := map[string]string{} // caller reuses the map
+	for _, label := range o.Labels {
+		if value, found := values[label]; found {
+			labels[label] = value
+		}
+	}
+	key := labelKey(labels)
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	count, found := o.counts[key]
+	if !found {
+		count = &otlpCount{labels: labels}
+		o.counts[key] = count
+	}
+	count.value++
+}
+
+func (o *Otlp) exportMetrics() {
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := strconv.FormatInt(o.started.UnixNano(), 10)
+	point := func(labels map[string]string, value uint64) otlpNumberPoint {
+		return otlpNumberPoint{Attributes: otlpAttributes(labels), StartTimeUnixNano: start, TimeUnixNano: now, AsInt: strconv.FormatUint(value, 10)}
+	}
+
+	logs := otlpMetric{Name: "logs_total", Description: "Total number of logs received", Sum: otlpSum{AggregationTemporality: 2, IsMonotonic: true, DataPoints: []otlpNumberPoint{}}}
+	o.mutex.Lock()
+	keys := make([]string, 0, len(o.counts))
+	for key := range o.counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		logs.Sum.DataPoints = append(logs.Sum.DataPoints, point(o.counts[key].labels, o.counts[key].value))
+	}
+	o.mutex.Unlock()
+	metrics := []otlpMetric{logs}
+
+	if len(o.Outputs) != 0 {
+		dropped := otlpMetric{Name: "logs_dropped_total", Description: "Total number of logs dropped because an output could not keep up", Sum: otlpSum{AggregationTemporality: 2, IsMonotonic: true}}
+		for _, output := range o.Outputs {
+			dropped.Sum.DataPoints = append(dropped.Sum.DataPoints, point(map[string]string{"output": output.Name()}, output.Dropped().Load()))
+		}
+		metrics = append(metrics, dropped)
+	}
+
+	body, err := json.Marshal(otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: otlpAttributes(o.Resource)},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: "logrecycler"}, Metrics: metrics}},
+	}}})
+	check(err)
+	o.send("/v1/metrics", body, o.stopping) // nothing is lost on failure since the next export has the same cumulative counts
+}
+
+func (o *Otlp) send(path string, body []byte, stop <-chan struct{}) bool {
+	url := strings.TrimRight(o.Endpoint, "/") + path
+	headers := map[string]string{"Content-Type": "application/json"}
+	for k, v := range o.Headers {
+		headers[k] = v
+	}
+
+	status, _, err := sendWithRetries(o.MaxRetries, otlpBackoff, stop, func() (int, []byte, error) {
+		return post(o.client, url, headers, body)
+	})
+	return err == nil && status/100 == 2
+}
+
+func (l *otlpLogs) Name() string {
+	return "otlp"
+}
+
+func (l *otlpLogs) Start() {
+	l.batcher.start(l.otlp.BufferSize, l.otlp.batchWait, l.add, l.flush)
+}
+
+func (l *otlpLogs) Stop() {
+	l.batcher.stop()
+}
+
+func (l *otlpLogs) Write(entry *Entry) {
+	l.batcher.write(entry)
+}
+
+func (l *otlpLogs) Dropped() *atomic.Uint64 {
+	return &l.batcher.dropped
+}
+
+func (l *otlpLogs) add(entry *Entry) bool {
+	l.batch = append(l.batch, entry)
+	return len(l.batch) >= l.otlp.BatchSize
+}
+
+func (l *otlpLogs) flush() {
+	batch := l.batch
+	if len(batch) == 0 {
+		return
+	}
+	l.batch = nil
+
+	records := make([]otlpLogRecord, len(batch))
+	for i, entry := range batch {
+		records[i] = l.otlp.logRecord(entry)
+	}
+	body, err := json.Marshal(otlpLogsRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource:  otlpResource{Attributes: otlpAttributes(l.otlp.Resource)},
+		ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: "logrecycler"}, LogRecords: records}},
+	}}})
+	check(err)
+
+	if !l.otlp.send("/v1/logs", body, l.batcher.stopping) {
+		l.batcher.dropped.Add(uint64(len(batch)))
+	}
+}
+
+// message becomes the body, level the severity and everything else attributes
+func (o *Otlp) logRecord(entry *Entry) otlpLogRecord {
+	record := otlpLogRecord{
+		TimeUnixNano: strconv.FormatInt(entry.time.UnixNano(), 10),
+		Body:         otlpAnyValue{StringValue: entry.log.values[o.messageKey]},
+		Attributes:   []otlpKeyValue{},
+	}
+	if entry.level != "" {
+		record.SeverityText = entry.level
+		if severity, found := levelSeverities[strings.ToUpper(entry.level)]; found {
+			record.SeverityNumber = severity*4 + 1 // TRACE=1 DEBUG=5 INFO=9 WARN=13 ERROR=17 FATAL=21
+		}
+	}
+	for _, key := range entry.log.keys {
+		if key == o.messageKey || key == o.timestampKey || key == o.levelKey {
+			continue
+		}
+		record.Attributes = append(record.Attributes, otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: entry.log.values[key]}})
+	}
+	return record
+}
+
+// sorted so requests are stable
+func otlpAttributes(values map[string]string) []otlpKeyValue {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attributes := make([]otlpKeyValue, len(keys))
+	for i, k := range keys {
+		attributes[i] = otlpKeyValue{Key: k, Value: otlpAnyValue{StringValue: values[k]}}
+	}
+	return attributes
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("otlp", func() {
+	var server *httptest.Server
+	var received map[string][]string
+	var statuses []int
+	var mutex sync.Mutex
+
+	BeforeEach(func() {
+		otlpBackoff = time.Millisecond
+		received = map[string][]string{}
+		statuses = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+			body, err := io.ReadAll(r.Body)
+			Expect(err).To(BeNil())
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			status := http.StatusOK
+			if len(statuses) != 0 {
+				status, statuses = statuses[0], statuses[1:]
+			}
+			if status == http.StatusOK {
+				received[r.URL.Path] = append(received[r.URL.Path], string(body))
+			}
+			w.WriteHeader(status)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	entry := func(keys ...string) *Entry {
+		log := NewOrderedMap()
+		for i := 0; i < len(keys); i += 2 {
+			log.Set(keys[i], keys[i+1])
+		}
+		return &Entry{time: time.Unix(1, 2), level: log.values["level"], log: log}
+	}
+
+	start := func(otlp *Otlp) *Otlp {
+		otlp.Endpoint = server.URL
+		otlp.Headers = map[string]string{"Authorization": "Bearer token"}
+		otlp.Resource = map[string]string{"service.name": "app"}
+		Expect(otlp.setup(&Config{TimestampKey: "ts", LevelKey: "level", MessageKey: "message"})).To(BeNil())
+		otlp.Start()
+		if otlp.logs != nil {
+			otlp.logs.Start()
+		}
+		return otlp
+	}
+
+	stop := func(otlp *Otlp) {
+		if otlp.logs != nil {
+			otlp.logs.Stop()
+		}
+		otlp.Stop()
+	}
+
+	// timestamps of metrics change with every export
+	withoutTimes := func(body string) string {
+		return regexp.MustCompile(`"(startT|t)imeUnixNano":"\d+"`).ReplaceAllString(body, `"${1}imeUnixNano":"0"`)
+	}
+
+	It("exports logs with severity, body and attributes", func() {
+		otlp := start(&Otlp{Signals: []string{"logs"}})
+		otlp.logs.Write(entry("ts", "x", "level", "WARN", "message", "hi", "pattern", "greeting"))
+		otlp.logs.Write(entry("message", "plain"))
+		stop(otlp)
+		Expect(received["/v1/logs"]).To(Equal([]string{
+			`{"resourceLogs":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"app"}}]},` +
+				`"scopeLogs":[{"scope":{"name":"logrecycler"},"logRecords":[` +
+				`{"timeUnixNano":"1000000002","severityNumber":13,"severityText":"WARN","body":{"stringValue":"hi"},"attributes":[{"key":"pattern","value":{"stringValue":"greeting"}}]},` +
+				`{"timeUnixNano":"1000000002","body":{"stringValue":"plain"},"attributes":[]}]}]}]}`,
+		}))
+		Expect(received["/v1/metrics"]).To(BeNil())
+		Expect(otlp.logs.Dropped().Load()).To(Equal(uint64(0)))
+	})
+
+	It("keeps unknown levels as text", func() {
+		otlp := &Otlp{}
+		Expect(otlp.logRecord(entry("level", "NOTICE")).SeverityText).To(Equal("NOTICE"))
+		Expect(otlp.logRecord(entry("level", "NOTICE")).SeverityNumber).To(Equal(0))
+		Expect(otlp.logRecord(entry("level", "trace")).SeverityNumber).To(Equal(1))
+	})
+
+	It("exports when the batch is full", func() {
+		otlp := start(&Otlp{Signals: []string{"logs"}, BatchSize: 1})
+		otlp.logs.Write(entry("message", "a"))
+		otlp.logs.Write(entry("message", "b"))
+		stop(otlp)
+		Expect(len(received["/v1/logs"])).To(Equal(2))
+	})
+
+	It("retries logs and drops them when the collector keeps failing", func() {
+		statuses = []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusBadRequest}
+		otlp := start(&Otlp{Signals: []string{"logs"}, BatchSize: 1})
+		otlp.logs.Write(entry("message", "a"))
+		otlp.logs.Write(entry("message", "b"))
+		stop(otlp)
+		Expect(len(received["/v1/logs"])).To(Equal(1))
+		Expect(otlp.logs.Dropped().Load()).To(Equal(uint64(1)))
+		Expect(otlp.logs.Name()).To(Equal("otlp"))
+	})
+
+	It("exports cumulative counters", func() {
+		otlp := start(&Otlp{Signals: []string{"metrics"}, Labels: []string{"pattern"}})
+		labels := map[string]string{"pattern": "a", "id": "1"}
+		otlp.Inc(labels)
+		labels["pattern"], labels["id"] = "b", "2" // maps are reused for the next line
+		otlp.Inc(labels)
+		otlp.Inc(map[string]string{"pattern": "a"})
+		otlp.Outputs = []dropper{&Loki{}}
+		otlp.Outputs[0].Dropped().Add(3)
+		stop(otlp)
+
+		Expect(len(received["/v1/metrics"])).To(Equal(1))
+		Expect(withoutTimes(received["/v1/metrics"][0])).To(Equal(
+			`{"resourceMetrics":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"app"}}]},` +
+				`"scopeMetrics":[{"scope":{"name":"logrecycler"},"metrics":[` +
+				`{"name":"logs_total","description":"Total number of logs received","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[` +
+				`{"attributes":[{"key":"pattern","value":{"stringValue":"a"}}],"startTimeUnixNano":"0","timeUnixNano":"0","asInt":"2"},` +
+				`{"attributes":[{"key":"pattern","value":{"stringValue":"b"}}],"startTimeUnixNano":"0","timeUnixNano":"0","asInt":"1"}]}},` +
+				`{"name":"logs_dropped_total","description":"Total number of logs dropped because an output could not keep up","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[` +
+				`{"attributes":[{"key":"output","value":{"stringValue":"loki"}}],"startTimeUnixNano":"0","timeUnixNano":"0","asInt":"3"}]}}]}]}]}`,
+		))
+		Expect(received["/v1/logs"]).To(BeNil())
+	})
+
+	It("exports metrics periodically", func() {
+		otlp := start(&Otlp{Signals: []string{"metrics"}, Interval: "10ms"})
+		otlp.Inc(map[string]string{})
+		Eventually(func() int {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return len(received["/v1/metrics"])
+		}).Should(BeNumerically(">=", 1))
+		stop(otlp)
+	})
+
+	It("ignores counts when not exporting metrics", func() {
+		otlp := start(&Otlp{Signals: []string{"logs"}})
+		otlp.Inc(map[string]string{"pattern": "a"})
+		stop(otlp)
+		Expect(otlp.counts).To(BeEmpty())
+	})
+
+	It("exports logs and metrics when configured", func() {
+		withConfig("otlp:\n  endpoint: "+server.URL+"\n  headers:\n    Authorization: Bearer token\n", func() {
+			Expect(runWithCommand("echo", "hi")).To(Equal(`{"message":"hi"}`))
+		})
+		Expect(len(received["/v1/logs"])).To(Equal(1))
+		Expect(received["/v1/logs"][0]).To(ContainSubstring(`"body":{"stringValue":"hi"}`))
+		Expect(len(received["/v1/metrics"])).To(Equal(1))
+		Expect(received["/v1/metrics"][0]).To(ContainSubstring(`"asInt":"1"`))
+		Expect(received["/v1/metrics"][0]).To(ContainSubstring(`{"key":"output","value":{"stringValue":"otlp"}}`))
+	})
+
+	It("only exports possible labels", func() {
+		withConfig("json: simple\notlp:\n  endpoint: "+server.URL+"\n  signals: [metrics]\n  headers:\n    Authorization: Bearer token\n", func() {
+			parse("{\"message\":\"a\",\"id\":\"1\"}\n{\"message\":\"b\",\"id\":\"2\"}\n")
+		})
+		Expect(len(received["/v1/metrics"])).To(Equal(1))
+		Expect(received["/v1/metrics"][0]).To(ContainSubstring(`"attributes":[],"startTimeUnixNano"`))
+		Expect(received["/v1/metrics"][0]).To(ContainSubstring(`"asInt":"2"`))
+		Expect(received["/v1/metrics"][0]).ToNot(ContainSubstring(`"id"`))
+	})
+
+	It("fails on invalid endpoint", func() {
+		withConfig("otlp:\n  endpoint: collector:4318", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("otlp.endpoint must start with http:// or https:// but was collector:4318"))
+		})
+	})
+
+	It("fails on invalid signals", func() {
+		withConfig("otlp:\n  endpoint: http://collector:4318\n  signals: [traces]", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("otlp.signals must be logs or metrics but was traces"))
+		})
+	})
+
+	It("fails on invalid interval", func() {
+		withConfig("otlp:\n  endpoint: http://collector:4318\n  interval: nope", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("otlp.interval must be a positive duration like 1s or 5m but was nope"))
+		})
+	})
+
+	It("fails on invalid batchWait", func() {
+		withConfig("otlp:\n  endpoint: http://collector:4318\n  batchWait: nope", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("otlp.batchWait must be a positive duration like 1s or 5m but was nope"))
+		})
+	})
+})
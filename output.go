@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var streamNames = []string{"stdout", "stderr"}
+
+var outputFormats = []string{"json", "logfmt", "console"}
+
+var overflowPolicies = []string{"block", "dropNewest", "dropOldest"}
+
+// levels from least to most severe
+var levelSeverities = map[string]int{
+	"TRACE": 0,
+	"DEBUG": 1,
+	"INFO":  2,
+	"WARN":  3,
+	"ERROR": 4,
+	"FATAL": 5,
+}
+
+type Output struct {
+	Stdout        *Route
+	Stderr        *Route
+	Format        string // json (default), logfmt or console
+	BufferSize    int    `yaml:"bufferSize"` // lines to keep in memory while stdout/stderr is slow, default 10000
+	Overflow      string // what to do when the buffer is full: block (default), dropNewest or dropOldest
+	Loki          *Loki
+	Elasticsearch *Elasticsearch
+	Syslog        *SyslogOutput
+	Gelf          *Gelf
+	File          *FileOutput
+	stdout        *asyncWriter
+	stderr        *asyncWriter
+	sinks         []Sink
+}
+
+// which logs to write, logs go to stderr when they match its route, otherwise to stdout when they match its route
+type Route struct {
+	Streams  []string // streams the log can come from, all when not set
+	MinLevel string   `yaml:"minLevel"`
+	minLevel int
+}
+
+func (o *Output) setup(config *Config) error {
+	if o.Format == "" {
+		o.Format = "json"
+	}
+	if !contains(outputFormats, o.Format) {
+		return fmt.Errorf("output.format must be one of json/logfmt/console but was %s", o.Format)
+	}
+	if o.BufferSize == 0 {
+		o.BufferSize = 10000
+	}
+	if o.Overflow == "" {
+		o.Overflow = "block"
+	}
+	if !contains(overflowPolicies, o.Overflow) {
+		return fmt.Errorf("output.overflow must be one of block/dropNewest/dropOldest but was %s", o.Overflow)
+	}
+	o.stdout = &asyncWriter{name: "stdout", color: isTerminal(os.Stdout), overflow: o.Overflow}
+	o.stderr = &asyncWriter{name: "stderr", color: isTerminal(os.Stderr), overflow: o.Overflow}
+
+	if o.Stdout == nil {
+		o.Stdout = &Route{}
+	}
+	if o.Stderr == nil {
+		o.Stderr = &Route{Streams: []string{"stderr"}}
+	}
+
+	if o.Loki != nil {
+		if err := o.Loki.setup(); err != nil {
+			return err
+		}
+		o.sinks = append(o.sinks, o.Loki)
+	}
+	if o.Elasticsearch != nil {
+		if err := o.Elasticsearch.setup(); err != nil {
+			return err
+		}
+		o.sinks = append(o.sinks, o.Elasticsearch)
+	}
+	if o.Syslog != nil {
+		if err := o.Syslog.setup(config); err != nil {
+			return err
+		}
+		o.sinks = append(o.sinks, o.Syslog)
+	}
+	if o.Gelf != nil {
+		if err := o.Gelf.setup(config); err != nil {
+			return err
+		}
+		o.sinks = append(o.sinks, o.Gelf)
+	}
+	if o.File != nil {
+		if err := o.File.setup(); err != nil {
+			return err
+		}
+		o.sinks = append(o.sinks, o.File)
+	}
+
+	for name, route := range map[string]*Route{"stdout": o.Stdout, "stderr": o.Stderr} {
+		for _, stream := range route.Streams {
+			if err := validateStream(stream); err != nil {
+				return err
+			}
+		}
+		if route.MinLevel != "" {
+			if !config.levelKeySet {
+				return fmt.Errorf("output.%s.minLevel needs levelKey to be set", name)
+			}
+			severity, found := levelSeverities[strings.ToUpper(route.MinLevel)]
+			if !found {
+				return fmt.Errorf("output.%s.minLevel must be one of TRACE/DEBUG/INFO/WARN/ERROR/FATAL but was %s", name, route.MinLevel)
+			}
+			route.minLevel = severity
+		}
+	}
+
+	return nil
+}
+
+func (o *Output) Start() {
+	o.stdout.start(os.Stdout, o.BufferSize)
+	o.stderr.start(os.Stderr, o.BufferSize)
+}
+
+// write everything that is buffered
+func (o *Output) Stop() {
+	o.stdout.stop()
+	o.stderr.stop()
+}
+
+// stdout/stderr when they can drop and all sinks, to report how much they dropped
+func (o *Output) droppers() []dropper {
+	droppers := []dropper{}
+	if o.Overflow != "block" {
+		droppers = append(droppers, o.stdout, o.stderr)
+	}
+	for _, sink := range o.sinks {
+		droppers = append(droppers, sink)
+	}
+	return droppers
+}
+
+// where to write a log, nil when it should not be written
+func (o *Output) destination(stream string, level string) *asyncWriter {
+	if o.Stderr.matches(stream, level) {
+		return o.stderr
+	}
+	if o.Stdout.matches(stream, level) {
+		return o.stdout
+	}
+	return nil
+}
+
+// serialize the log for the given destination, only colorizing when a human is watching
+func (o *Output) serialize(log *OrderedMap, config *Config, out *asyncWriter) string {
+	switch o.Format {
+	case "logfmt":
+		return log.ToLogfmt()
+	case "console":
+		color := out != nil && out.color
+		return log.ToConsole(config.TimestampKey, config.LevelKey, config.MessageKey, color)
+	default:
+		return log.ToJson()
+	}
+}
+
+func (r *Route) matches(stream string, level string) bool {
+	if r.Streams != nil && !contains(r.Streams, stream) {
+		return false
+	}
+	if r.MinLevel != "" {
+		severity, found := levelSeverities[strings.ToUpper(level)]
+		if !found || severity < r.minLevel {
+			return false
+		}
+	}
+	return true
+}
+
+func validateStream(stream string) error {
+	if !contains(streamNames, stream) {
+		return fmt.Errorf("stream must be one of stdout/stderr but was %s", stream)
+	}
+	return nil
+}
+
+// writes lines from a separate goroutine so a slow reader of stdout/stderr does not stall processing,
+// flushing whenever it caught up
+type asyncWriter struct {
+	name     string
+	color    bool
+	overflow string
+	lines    chan string
+	done     chan struct{}
+	dropped  atomic.Uint64
+}
+
+func (w *asyncWriter) Name() string {
+	return w.name
+}
+
+func (w *asyncWriter) Dropped() *atomic.Uint64 {
+	return &w.dropped
+}
+
+func (w *asyncWriter) start(file *os.File, bufferSize int) {
+	w.lines = make(chan string, bufferSize)
+	w.done = make(chan struct{})
+
+	go func() {
+		defer close(w.done)
+		writer := bufio.NewWriter(file)
+		for line := range w.lines {
+			_, _ = writer.WriteString(line)
+			_ = writer.WriteByte('\n')
+			if len(w.lines) == 0 {
+				_ = writer.Flush()
+			}
+		}
+		_ = writer.Flush()
+	}()
+}
+
+func (w *asyncWriter) stop() {
+	close(w.lines)
+	<-w.done
+}
+
+func (w *asyncWriter) write(line string) {
+	switch w.overflow {
+	case "dropNewest":
+		select {
+		case w.lines <- line:
+		default:
+			w.dropped.Add(1)
+		}
+	case "dropOldest":
+		for {
+			select {
+			case w.lines <- line:
+				return
+			default:
+			}
+			select {
+			case <-w.lines:
+				w.dropped.Add(1)
+			default: // untested section
+			}
+		}
+	default:
+		w.lines <- line
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("output", func() {
+	// process lines from the given streams and return what was written to stdout and stderr
+	process := func(config string, lines ...StreamLine) (stdout string, stderr string) {
+		withConfig(config, func() {
+			c, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			stderr = captureStderr(func() {
+				stdout = captureStdout(func() {
+					c.Output.Start()
+					for _, line := range lines {
+						processLine(line, c)
+					}
+					c.Output.Stop()
+				})
+			})
+		})
+		return
+	}
+
+	It("writes to where the line came from by default", func() {
+		stdout, stderr := process("", StreamLine{line: "out"}, StreamLine{index: 1, line: "err"})
+		Expect(stdout).To(Equal("{\"message\":\"out\"}\n"))
+		Expect(stderr).To(Equal("{\"message\":\"err\"}\n"))
+	})
+
+	It("can add the stream", func() {
+		stdout, stderr := process("---\nstreamKey: stream", StreamLine{line: "out"}, StreamLine{index: 1, line: "err"})
+		Expect(stdout).To(Equal("{\"message\":\"out\",\"stream\":\"stdout\"}\n"))
+		Expect(stderr).To(Equal("{\"message\":\"err\",\"stream\":\"stderr\"}\n"))
+	})
+
+	It("can use the stream as label", func() {
+		withConfig("---\nstreamKey: stream", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(config.possibleLabels()).To(Equal([]string{"stream"}))
+		})
+	})
+
+	It("can set the default level per stream", func() {
+		stdout, stderr := process("---\nlevelKey: level\nstreamLevels:\n  stderr: WARN\npatterns:\n- regex: error\n  level: ERROR",
+			StreamLine{line: "out"}, StreamLine{index: 1, line: "err"}, StreamLine{index: 1, line: "error"})
+		Expect(stdout).To(Equal("{\"level\":\"INFO\",\"message\":\"out\"}\n"))
+		Expect(stderr).To(Equal("{\"level\":\"WARN\",\"message\":\"err\"}\n{\"level\":\"ERROR\",\"message\":\"error\"}\n"))
+	})
+
+	It("can send everything to stdout", func() {
+		stdout, stderr := process("---\noutput:\n  stderr:\n    streams: []", StreamLine{line: "out"}, StreamLine{index: 1, line: "err"})
+		Expect(stdout).To(Equal("{\"message\":\"out\"}\n{\"message\":\"err\"}\n"))
+		Expect(stderr).To(Equal(""))
+	})
+
+	It("can send only severe logs to stderr", func() {
+		stdout, stderr := process("---\nlevelKey: level\noutput:\n  stderr:\n    minLevel: error\npatterns:\n- regex: error\n  level: ERROR",
+			StreamLine{line: "error"}, StreamLine{index: 1, line: "err"})
+		Expect(stdout).To(Equal("{\"level\":\"INFO\",\"message\":\"err\"}\n"))
+		Expect(stderr).To(Equal("{\"level\":\"ERROR\",\"message\":\"error\"}\n"))
+	})
+
+	It("does not write logs that match no route", func() {
+		stdout, stderr := process("---\nlevelKey: level\noutput:\n  stdout:\n    minLevel: WARN\npatterns:\n- regex: warn\n  level: WARN\n- regex: weird\n  level: WEIRD",
+			StreamLine{line: "info"}, StreamLine{line: "warn"}, StreamLine{line: "weird"})
+		Expect(stdout).To(Equal("{\"level\":\"WARN\",\"message\":\"warn\"}\n"))
+		Expect(stderr).To(Equal(""))
+	})
+
+	Describe("format", func() {
+		It("can write logfmt", func() {
+			stdout, _ := process("---\nlevelKey: level\noutput:\n  format: logfmt\npatterns:\n- regex: (?P<a>x)(?P<b>y)(?P<c>z?)\n  add:\n    d e: f\\g",
+				StreamLine{line: "hi \"xy\"="})
+			Expect(stdout).To(Equal("level=INFO message=\"hi \\\"xy\\\"=\" a=x b=y c=\"\" d_e=\"f\\\\g\"\n"))
+		})
+
+		It("can write console", func() {
+			stdout, _ := process("---\nlevelKey: level\ntimestampKey: ts\noutput:\n  format: console\npatterns:\n- regex: (?P<a>x)",
+				StreamLine{line: "hi x", time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)})
+			Expect(stdout).To(Equal("2024-01-01T00:00:00Z INFO hi x a=x\n"))
+		})
+
+		It("can write console without timestamp and level", func() {
+			stdout, _ := process("---\noutput:\n  format: console", StreamLine{line: "hi"})
+			Expect(stdout).To(Equal("hi\n"))
+		})
+
+		It("colors console levels", func() {
+			log := NewOrderedMap()
+			log.Set("level", "error")
+			log.Set("message", "hi")
+			log.Set("a", "b")
+			Expect(log.ToConsole("", "level", "message", true)).To(Equal("\033[31merror\033[0m hi \033[90ma=\033[0mb"))
+		})
+
+		It("escapes json like json.Marshal", func() {
+			values := []string{"", "plain", "ünïcode 日本", "\xff\xfeinvalid\xc3", "line\u2028sep\u2029", "\U0001F600"}
+			for c := 0; c < utf8.RuneSelf; c++ {
+				values = append(values, "a"+string(rune(c))+"b")
+			}
+			for _, value := range values {
+				log := NewOrderedMap()
+				log.Set(value, value)
+				key, err := json.Marshal(value)
+				Expect(err).To(BeNil())
+				Expect(log.ToJson()).To(Equal("{"+string(key)+":"+string(key)+"}"), value)
+			}
+		})
+
+		It("fails on unknown format", func() {
+			withConfig("---\noutput:\n  format: wut", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("output.format must be one of json/logfmt/console but was wut"))
+			})
+		})
+	})
+
+	It("fails on unknown streams", func() {
+		withConfig("---\noutput:\n  stdout:\n    streams: [wut]", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("stream must be one of stdout/stderr but was wut"))
+		})
+	})
+
+	It("fails on unknown stream levels", func() {
+		withConfig("---\nstreamLevels:\n  wut: INFO", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("stream must be one of stdout/stderr but was wut"))
+		})
+	})
+
+	It("fails on minLevel without levelKey", func() {
+		withConfig("---\noutput:\n  stderr:\n    minLevel: ERROR", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.stderr.minLevel needs levelKey to be set"))
+		})
+	})
+
+	It("fails on unknown minLevel", func() {
+		withConfig("---\nlevelKey: level\noutput:\n  stderr:\n    minLevel: WUT", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.stderr.minLevel must be one of TRACE/DEBUG/INFO/WARN/ERROR/FATAL but was WUT"))
+		})
+	})
+
+	Describe("overflow", func() {
+		// a writer that is not started, so nothing is taken out of the buffer
+		stalled := func(overflow string) *asyncWriter {
+			return &asyncWriter{name: "stdout", overflow: overflow, lines: make(chan string, 2)}
+		}
+
+		buffered := func(w *asyncWriter) []string {
+			close(w.lines)
+			lines := []string{}
+			for line := range w.lines {
+				lines = append(lines, line)
+			}
+			return lines
+		}
+
+		It("drops the newest lines", func() {
+			w := stalled("dropNewest")
+			for _, line := range []string{"a", "b", "c", "d"} {
+				w.write(line)
+			}
+			Expect(buffered(w)).To(Equal([]string{"a", "b"}))
+			Expect(w.Dropped().Load()).To(Equal(uint64(2)))
+		})
+
+		It("drops the oldest lines", func() {
+			w := stalled("dropOldest")
+			for _, line := range []string{"a", "b", "c", "d"} {
+				w.write(line)
+			}
+			Expect(buffered(w)).To(Equal([]string{"c", "d"}))
+			Expect(w.Dropped().Load()).To(Equal(uint64(2)))
+		})
+
+		It("writes everything when blocking", func() {
+			stdout, _ := process("---\noutput:\n  bufferSize: 1", StreamLine{line: "a"}, StreamLine{line: "b"}, StreamLine{line: "c"})
+			Expect(stdout).To(Equal("{\"message\":\"a\"}\n{\"message\":\"b\"}\n{\"message\":\"c\"}\n"))
+		})
+
+		It("reports dropped lines when dropping", func() {
+			port := randomPort()
+			withConfig("---\nprometheus:\n  port: "+port+"\noutput:\n  overflow: dropOldest", func() {
+				metrics := prometheusMetrics(port)
+				Expect(metrics).To(ContainSubstring("logs_dropped_total{output=\"stdout\"} 0\n"))
+				Expect(metrics).To(ContainSubstring("logs_dropped_total{output=\"stderr\"} 0\n"))
+			})
+		})
+
+		It("does not report dropped lines when blocking", func() {
+			port := randomPort()
+			withConfig("---\nprometheus:\n  port: "+port, func() {
+				Expect(prometheusMetrics(port)).ToNot(ContainSubstring("logs_dropped_total"))
+			})
+		})
+
+		It("fails on unknown overflow", func() {
+			withConfig("---\noutput:\n  overflow: wut", func() {
+				_, err := NewConfig("logrecycler.yaml")
+				Expect(err.Error()).To(Equal("output.overflow must be one of block/dropNewest/dropOldest but was wut"))
+			})
+		})
+	})
+})
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// skips patterns that cannot match because a literal they require is not in the message,
+// so unknown lines do not run every regex before reaching the catch-all
+type patternFilter struct {
+	matcher *literalMatcher
+	always  bitset // patterns without a required literal
+}
+
+type bitset []uint64
+
+func newBitset(size int) bitset {
+	return make(bitset, (size+63)/64)
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << (i % 64)
+}
+
+func (b bitset) has(i int) bool {
+	return b[i/64]&(1<<(i%64)) != 0
+}
+
+// nil when no pattern has a required literal since then nothing can be skipped
+func newPatternFilter(patterns []Pattern) *patternFilter {
+	literals := make([]string, len(patterns))
+	always := newBitset(len(patterns))
+	found := false
+	for i, pattern := range patterns {
+		literals[i] = requiredLiteral(pattern.Regex)
+		if literals[i] == "" {
+			always.set(i)
+		} else {
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &patternFilter{matcher: newLiteralMatcher(literals), always: always}
+}
+
+// patterns that could match the message
+func (f *patternFilter) candidates(message string) bitset {
+	candidates := make(bitset, len(f.always))
+	copy(candidates, f.always)
+	f.matcher.match(message, candidates)
+	return candidates
+}
+
+// longest literal every match of the regex contains, empty when there is none
+func requiredLiteral(regex string) string {
+	parsed, err := syntax.Parse(regex, syntax.Perl)
+	if err != nil {
+		return "" // compiling the regex reports the error
+	}
+	return longestRequired(parsed.Simplify())
+}
+
+func longestRequired(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == utf8.RuneError {
+				return "" // also matches invalid utf8, which does not contain the literal
+			}
+		}
+		if re.Flags&syntax.FoldCase != 0 {
+			return "" // case insensitive
+		}
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus: // simplify already turned repeats into these
+		return longestRequired(re.Sub[0])
+	case syntax.OpConcat:
+		longest := ""
+		for _, sub := range re.Sub {
+			if literal := longestRequired(sub); len(literal) > len(longest) {
+				longest = literal
+			}
+		}
+		return longest
+	default: // alternations, optional parts, character classes ...
+		return ""
+	}
+}
+
+// aho-corasick automaton that finds all literals in a single pass
+// https://en.wikipedia.org/wiki/Aho%E2%80%93Corasick_algorithm
+type literalMatcher struct {
+	next    [][256]int32 // state transitions for every byte, failures are already resolved
+	outputs [][]int      // literal indexes that end in the state
+}
+
+func newLiteralMatcher(literals []string) *literalMatcher {
+	m := &literalMatcher{next: make([][256]int32, 1), outputs: make([][]int, 1)}
+
+	// trie, -1 means no transition yet
+	for i := range m.next[0] {
+		m.next[0][i] = -1
+	}
+	for i, literal := range literals {
+		if literal == "" {
+			continue
+		}
+		state := int32(0)
+		for j := 0; j < len(literal); j++ {
+			b := literal[j]
+			if m.next[state][b] == -1 {
+				m.next = append(m.next, [256]int32{})
+				m.outputs = append(m.outputs, nil)
+				for k := range m.next[len(m.next)-1] {
+					m.next[len(m.next)-1][k] = -1
+				}
+				m.next[state][b] = int32(len(m.next) - 1)
+			}
+			state = m.next[state][b]
+		}
+		m.outputs[state] = append(m.outputs[state], i)
+	}
+
+	// breadth first so failures of shorter prefixes are known, then turn missing transitions into failure transitions
+	fail := make([]int32, len(m.next))
+	queue := []int32{}
+	for b := 0; b < 256; b++ {
+		if child := m.next[0][b]; child == -1 {
+			m.next[0][b] = 0
+		} else {
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) != 0 {
+		state := queue[0]
+		queue = queue[1:]
+		m.outputs[state] = append(m.outputs[state], m.outputs[fail[state]]...)
+		for b := 0; b < 256; b++ {
+			if child := m.next[state][b]; child == -1 {
+				m.next[state][b] = m.next[fail[state]][b]
+			} else {
+				fail[child] = m.next[fail[state]][b]
+				queue = append(queue, child)
+			}
+		}
+	}
+	return m
+}
+
+// mark all literals that are in the text
+func (m *literalMatcher) match(text string, found bitset) {
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		state = m.next[state][text[i]]
+		for _, literal := range m.outputs[state] {
+			found.set(literal)
+		}
+	}
+}
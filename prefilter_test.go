@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// lines that hit every pattern of the example config except the sampled one
+var exampleLines = []string{
+	"error while parsing the config",
+	"error connecting to remote host foobar.com:1234",
+	"the secret key is 123",
+	"todays weather is sunny",
+	"request took 12ms",
+	"I0530 10:13:00.740596      33 foo.go:132] something else",
+	"",
+}
+
+var _ = Describe("prefilter", func() {
+	It("finds the longest required literal", func() {
+		for regex, literal := range map[string]string{
+			"":               "",
+			"error.*parsing": "parsing",
+			`(?P<message>error connecting .*) (?P<host>\S+):(?P<port>\d+)`: "error connecting ",
+			"(?i)error":           "",
+			"error|warning":       "",
+			"(foo)?bar":           "bar",
+			"(abc)+d":             "abc",
+			"(ab){2}":             "ab",
+			"(abc){0,2}d":         "d",
+			"^todays weather is$": "todays weather is",
+			`\x{FFFD}x`:           "",
+			"[a-z]+":              "",
+			"(":                   "",
+		} {
+			Expect(requiredLiteral(regex)).To(Equal(literal), regex)
+		}
+	})
+
+	It("finds all literals in one pass, even when they overlap", func() {
+		matcher := newLiteralMatcher([]string{"he", "she", "", "his", "hers"})
+		found := newBitset(5)
+		matcher.match("ushers", found)
+		Expect([]bool{found.has(0), found.has(1), found.has(2), found.has(3), found.has(4)}).To(Equal([]bool{true, true, false, false, true}))
+	})
+
+	It("has no filter when nothing can be skipped", func() {
+		Expect(newPatternFilter([]Pattern{{Regex: ""}, {Regex: "(?i)a"}})).To(BeNil())
+	})
+
+	It("keeps patterns without literals as candidates", func() {
+		patterns := []Pattern{}
+		for i := 0; i < 100; i++ {
+			patterns = append(patterns, Pattern{Regex: fmt.Sprintf("pattern-%d$", i)})
+		}
+		patterns = append(patterns, Pattern{Regex: ""})
+		candidates := newPatternFilter(patterns).candidates("pattern-99")
+		for i := range patterns {
+			Expect(candidates.has(i)).To(Equal(i == 9 || i == 99 || i == 100), fmt.Sprint(i))
+		}
+	})
+
+	It("produces the same output as running every regex", func() {
+		config, err := NewConfig("logrecycler.yaml")
+		Expect(err).To(BeNil())
+		Expect(config.patternFilter).ToNot(BeNil())
+		for _, line := range exampleLines {
+			filter := config.patternFilter
+			filtered := prepareLine(StreamLine{line: line}, config)
+			config.patternFilter = nil
+			unfiltered := prepareLine(StreamLine{line: line}, config)
+			config.patternFilter = filter
+			if unfiltered == nil {
+				Expect(filtered).To(BeNil(), line) // discarded
+				continue
+			}
+			Expect(filtered.serialized).To(Equal(unfiltered.serialized), line)
+			Expect(filtered.labels).To(Equal(unfiltered.labels), line)
+		}
+	})
+})
+
+// go test -run none -bench Patterns -benchmem
+// compares running every regex with only running the regexes of patterns that can match
+func BenchmarkPatterns(b *testing.B) {
+	many := "patterns:\n"
+	for i := 0; i < 30; i++ {
+		many += fmt.Sprintf("- regex: 'error %d.*(?P<host>\\S+):(?P<port>\\d+)'\n  add: {pattern: p%d}\n", i, i)
+	}
+	many += "- regex: ''\n  add: {pattern: unknown}\n"
+	example, err := os.ReadFile("logrecycler.yaml")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for name, content := range map[string]string{"example": string(example), "30-patterns": many} {
+		path := b.TempDir() + "/logrecycler.yaml"
+		if err = os.WriteFile(path, []byte(content), 0644); err != nil {
+			b.Fatal(err)
+		}
+		config, err := NewConfig(path)
+		if err != nil {
+			b.Fatal(err)
+		}
+		lines := append(exampleLines, "error 29 connecting to "+strings.Repeat("x", 100)+":443")
+
+		prefilter := config.patternFilter
+		for _, filtered := range []bool{false, true} {
+			config.patternFilter = nil
+			if filtered {
+				config.patternFilter = prefilter
+			}
+			b.Run(fmt.Sprintf("%s/prefilter=%t", name, filtered), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					prepareLine(StreamLine{line: lines[i%len(lines)]}, config)
+				}
+			})
+		}
+	}
+}
@@ -5,14 +5,19 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"maps"
 	"net/http"
 )
 
 type Prometheus struct {
-	Port   string
-	Labels []string
-	Metric *prometheus.CounterVec
-	server *http.Server
+	Port        string
+	Labels      []string
+	ConstLabels []string          `yaml:"constLabels"` // fields to add to every metric
+	Outputs     []dropper         `yaml:"-"`
+	Templates   *Templates        `yaml:"-"` // served on /debug/templates when set
+	Constants   prometheus.Labels `yaml:"-"` // values of the const labels
+	Metric      *prometheus.CounterVec
+	server      *http.Server
 }
 
 func (p *Prometheus) Start() {
@@ -20,13 +25,26 @@ func (p *Prometheus) Start() {
 	// https://stackoverflow.com/questions/35117993/how-to-disable-go-collector-metrics-in-prometheus-client-golang
 	r := prometheus.NewRegistry()
 	p.Metric = promauto.With(r).NewCounterVec(prometheus.CounterOpts{
-		Name: "logs_total",
-		Help: "Total number of logs received",
+		Name:        "logs_total",
+		Help:        "Total number of logs received",
+		ConstLabels: p.Constants,
 	}, p.Labels)
-	handler := promhttp.HandlerFor(r, promhttp.HandlerOpts{})
+	for _, output := range p.Outputs {
+		dropped := output.Dropped()
+		promauto.With(r).NewCounterFunc(prometheus.CounterOpts{
+			Name:        "logs_dropped_total",
+			Help:        "Total number of logs dropped because an output could not keep up",
+			ConstLabels: mergeLabels(p.Constants, prometheus.Labels{"output": output.Name()}),
+		}, func() float64 { return float64(dropped.Load()) })
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", promhttp.HandlerFor(r, promhttp.HandlerOpts{}))
+	if p.Templates != nil {
+		mux.Handle("/debug/templates", p.Templates)
+	}
 
 	// serve metrics
-	p.server = &http.Server{Addr: "0.0.0.0:" + p.Port, Handler: handler}
+	p.server = &http.Server{Addr: "0.0.0.0:" + p.Port, Handler: mux}
 	go p.server.ListenAndServe()
 }
 
@@ -51,3 +69,10 @@ func (p *Prometheus) labelValues(labelMap map[string]string) []string {
 	}
 	return values
 }
+
+func mergeLabels(labels prometheus.Labels, add prometheus.Labels) prometheus.Labels {
+	merged := prometheus.Labels{}
+	maps.Copy(merged, labels)
+	maps.Copy(merged, add)
+	return merged
+}
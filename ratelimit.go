@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const suppressedKey = "suppressed"
+
+// token bucket per pattern (and optionally per field value) that stops retry storms from flooding output,
+// suppressed lines are still counted in metrics and summarized once per `per`
+type RateLimit struct {
+	Count   int    // lines per `per`, also the biggest burst that is let through
+	Per     string // default 1s
+	By      string // limit each value of this field separately, for example a capture like host
+	per     time.Duration
+	mutex   sync.Mutex
+	buckets map[string]*rateLimitBucket
+}
+
+type rateLimitBucket struct {
+	tokens     float64
+	updated    time.Time
+	suppressed int
+	first      time.Time   // when the first line was suppressed
+	log        *OrderedMap // first suppressed line, to build the summary from
+	enriched   []string    // keys of the log to keep out of metrics
+	stream     string
+	fromInput  bool // line had fields from its input
+}
+
+func (r *RateLimit) setup(location string) (err error) {
+	if r.Count <= 0 {
+		return fmt.Errorf("%s.count must be positive but was %d", location, r.Count)
+	}
+	if r.per, err = parseDuration(r.Per, time.Second, location+".per"); err != nil {
+		return err
+	}
+	if r.Per == "" {
+		r.Per = "1s"
+	}
+	r.buckets = map[string]*rateLimitBucket{}
+	return nil
+}
+
+// take a token or remember the line for the summary
+func (r *RateLimit) allow(log *OrderedMap, enriched []string, line StreamLine, now time.Time) bool {
+	key := log.values[r.By]
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	bucket, found := r.buckets[key]
+	if !found {
+		bucket = &rateLimitBucket{tokens: float64(r.Count), updated: now}
+		r.buckets[key] = bucket
+	}
+
+	// refill for the time that passed
+	bucket.tokens += now.Sub(bucket.updated).Seconds() / r.per.Seconds() * float64(r.Count)
+	if bucket.tokens > float64(r.Count) {
+		bucket.tokens = float64(r.Count)
+	}
+	bucket.updated = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+	if bucket.suppressed == 0 {
+		bucket.first = now
+		bucket.log = log.Copy()
+		bucket.enriched = enriched
+		bucket.stream = streamNames[line.index]
+		bucket.fromInput = line.fields != nil
+	}
+	bucket.suppressed++
+	return false
+}
+
+// bursts that were suppressed for `per` (or all when stopping), forgetting buckets that are full again
+func (r *RateLimit) summaries(now time.Time, all bool) (summaries []*rateLimitBucket) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for key, bucket := range r.buckets {
+		if bucket.suppressed != 0 && (all || now.Sub(bucket.first) >= r.per) {
+			summary := *bucket
+			summaries = append(summaries, &summary)
+			bucket.suppressed = 0
+			bucket.log = nil
+			bucket.enriched = nil
+		} else if bucket.suppressed == 0 && now.Sub(bucket.updated) >= r.per {
+			delete(r.buckets, key) // would be full, so no need to remember it
+		}
+	}
+	return summaries
+}
+
+func writeRateLimitSummaries(config *Config, all bool) {
+	now := time.Now()
+	for _, pattern := range config.Patterns {
+		if pattern.RateLimit == nil {
+			continue
+		}
+		for _, summary := range pattern.RateLimit.summaries(now, all) {
+			log := summary.log
+			labels := metricLabels(maps.Clone(log.values), config, pattern.IgnoreMetricLabels, summary.fromInput)
+			for _, key := range summary.enriched {
+				delete(labels, key)
+			}
+			if config.timestampKeySet {
+				log.values[config.TimestampKey] = now.Format(timeFormat)
+			}
+			log.values[config.MessageKey] = fmt.Sprintf("suppressed %d similar lines in %s", summary.suppressed, pattern.RateLimit.Per)
+			log.SetRaw(suppressedKey, strconv.Itoa(summary.suppressed)) // a number so it can be summed
+
+			writeSummary(config, log, summary.stream, now, labels)
+		}
+	}
+}
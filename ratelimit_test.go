@@ -0,0 +1,158 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("rate limit", func() {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	limit := func(r *RateLimit) *RateLimit {
+		Expect(r.setup("rateLimit")).To(BeNil())
+		return r
+	}
+
+	log := func(kv ...string) *OrderedMap {
+		m := NewOrderedMap()
+		for i := 0; i < len(kv); i += 2 {
+			m.Set(kv[i], kv[i+1])
+		}
+		return m
+	}
+
+	It("lets bursts through and refills over time", func() {
+		r := limit(&RateLimit{Count: 2, Per: "10s"})
+		allowed := func(at time.Duration) bool { return r.allow(log(), nil, StreamLine{}, start.Add(at)) }
+		Expect([]bool{allowed(0), allowed(0), allowed(0), allowed(5 * time.Second), allowed(5 * time.Second)}).To(Equal(
+			[]bool{true, true, false, true, false},
+		))
+
+		Expect(r.summaries(start.Add(5*time.Second), false)).To(BeEmpty()) // still suppressing
+		summaries := r.summaries(start.Add(10*time.Second), false)
+		Expect(len(summaries)).To(Equal(1))
+		Expect(summaries[0].suppressed).To(Equal(2))
+		Expect(r.summaries(start.Add(10*time.Second), false)).To(BeEmpty())
+
+		Expect(r.buckets).ToNot(BeEmpty())
+		r.summaries(start.Add(20*time.Second), false)
+		Expect(r.buckets).To(BeEmpty()) // full again
+	})
+
+	It("limits every value of a field separately", func() {
+		r := limit(&RateLimit{Count: 1, By: "host"})
+		Expect(r.allow(log("host", "a"), nil, StreamLine{}, start)).To(BeTrue())
+		Expect(r.allow(log("host", "b"), nil, StreamLine{}, start)).To(BeTrue())
+		Expect(r.allow(log("host", "a"), nil, StreamLine{}, start)).To(BeFalse())
+		Expect(r.allow(log("host", "b"), nil, StreamLine{}, start.Add(time.Hour))).To(BeTrue())
+		Expect(r.allow(log("host", "b"), nil, StreamLine{}, start.Add(time.Hour))).To(BeFalse()) // no more than count
+		Expect(r.Per).To(Equal("1s"))
+
+		summaries := r.summaries(start, true)
+		Expect(len(summaries)).To(Equal(2))
+	})
+
+	It("writes a summary of suppressed lines", func() {
+		withConfig("---\npatterns:\n- regex: ho\n- regex: hi\n  rateLimit: {count: 2, per: 1m}\n  add: {pattern: hi}", func() {
+			Expect(parse(strings.Repeat("hi\n", 5) + "ho")).To(Equal(
+				"{\"message\":\"hi\",\"pattern\":\"hi\"}\n" +
+					"{\"message\":\"hi\",\"pattern\":\"hi\"}\n" +
+					"{\"message\":\"ho\"}\n" +
+					"{\"message\":\"suppressed 3 similar lines in 1m\",\"pattern\":\"hi\",\"suppressed\":3}",
+			))
+		})
+	})
+
+	It("counts suppressed lines in metrics and gives summaries their labels", func() {
+		withConfig("---\ntimestampKey: ts\noutput:\n  stdout: {minLevel: ERROR}\nlevelKey: level\npatterns:\n- regex: 'hi (?P<host>\\S+) (?P<id>\\d+)'\n  rateLimit: {count: 1, by: host}\n  ignoreMetricLabels: [id]", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			sink := &recordingSink{}
+			config.Output.sinks = []Sink{sink}
+
+			Expect(prepareLine(StreamLine{line: "hi a 1"}, config).out).To(BeNil()) // routed nowhere
+			processed := prepareLine(StreamLine{line: "hi a 2"}, config)
+			Expect(processed.entry).To(BeNil())
+			Expect(processed.labels).To(Equal(map[string]string{"level": "INFO", "host": "a"}))
+
+			writeRateLimitSummaries(config, true)
+			Expect(len(sink.entries)).To(Equal(1))
+			Expect(sink.entries[0].labels).To(Equal(map[string]string{"level": "INFO", "host": "a"}))
+			Expect(sink.entries[0].line).To(MatchRegexp(`^\{"ts":"[^"]+","level":"INFO","message":"suppressed 1 similar lines in 1s","host":"a","id":"2","suppressed":1\}$`))
+		})
+	})
+
+	It("writes summaries after enrich and level normalization", func() {
+		dir, err := os.MkdirTemp("", "logrecycler")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "owners.csv")
+		Expect(os.WriteFile(path, []byte("code,owner\nE1,db\n"), 0644)).To(BeNil())
+		withConfig("---\noutput:\n  stdout: {minLevel: ERROR}\nlevelKey: level\nlevelMap: {}\nenrich:\n- {field: code, file: "+path+"}\npatterns:\n- regex: '(?P<level>\\w+) (?P<code>E\\d)'\n  rateLimit: {count: 1, by: owner}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			sink := &recordingSink{}
+			config.Output.sinks = []Sink{sink}
+
+			Expect(prepareLine(StreamLine{line: "warning E1"}, config).entry).ToNot(BeNil())
+			Expect(prepareLine(StreamLine{line: "warning E1"}, config).entry).To(BeNil()) // limited by the enriched owner
+
+			writeRateLimitSummaries(config, true)
+			Expect(len(sink.entries)).To(Equal(1))
+			Expect(sink.entries[0].labels).To(Equal(map[string]string{"level": "WARN", "code": "E1"}))
+			Expect(sink.entries[0].line).To(Equal(`{"level":"WARN","message":"suppressed 1 similar lines in 1s","code":"E1","owner":"db","suppressed":1}`))
+		})
+	})
+
+	It("writes summaries periodically", func() {
+		original := summaryInterval
+		summaryInterval = time.Millisecond
+		defer func() { summaryInterval = original }()
+
+		withConfig("---\npatterns:\n- regex: hi\n  rateLimit: {count: 1, per: 1ms}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			stdout := captureStdout(func() {
+				config.Output.Start()
+				stop := startSummaries(config)
+				processLine(StreamLine{line: "hi"}, config)
+				processLine(StreamLine{line: "hi"}, config)
+				Eventually(func() int {
+					config.Patterns[0].RateLimit.mutex.Lock()
+					defer config.Patterns[0].RateLimit.mutex.Unlock()
+					return len(config.Patterns[0].RateLimit.buckets)
+				}).Should(Equal(0))
+				stop()
+				config.Output.Stop()
+			})
+			Expect(stdout).To(Equal("{\"message\":\"hi\"}\n{\"message\":\"suppressed 1 similar lines in 1ms\",\"suppressed\":1}\n"))
+		})
+	})
+
+	It("does nothing without rate limits", func() {
+		withConfig("---\npatterns:\n- regex: hi", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			startSummaries(config)()
+		})
+	})
+
+	It("fails on invalid count", func() {
+		withConfig("---\npatterns:\n- regex: hi\n  rateLimit: {per: 1s}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("patterns[0].rateLimit.count must be positive but was 0"))
+		})
+	})
+
+	It("fails on invalid per", func() {
+		withConfig("---\npatterns:\n- regex: hi\n  rateLimit: {count: 1, per: often}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("patterns[0].rateLimit.per must be a positive duration like 1s or 5m but was often"))
+		})
+	})
+})
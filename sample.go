@@ -0,0 +1,52 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// decides which lines of sampled patterns to keep, safe to use from multiple workers
+type sampler struct {
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// seeded to make tests and replays reproducible, otherwise random
+func newSampler(seed *int64) *sampler {
+	source := time.Now().UnixNano()
+	if seed != nil {
+		source = *seed
+	}
+	return &sampler{random: rand.New(rand.NewSource(source))}
+}
+
+// lines with the same sampleBy value are all kept or all dropped, others are picked randomly
+func (s *sampler) keep(pattern *Pattern, values map[string]string) bool {
+	rate := *pattern.SampleRate
+	if pattern.SampleBy != "" {
+		return sampleHash(values[pattern.SampleBy]) < rate
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.random.Float32() < rate
+}
+
+// evenly distributed between 0 and 1 and the same on every host so all services keep the same requests
+func sampleHash(value string) float32 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(value))
+	// fnv does not mix the high bits of similar keys like r1/r2 well, so finalize like murmur3
+	sum := hash.Sum64()
+	sum ^= sum >> 33
+	sum *= 0xff51afd7ed558ccd
+	sum ^= sum >> 33
+	return float32(sum>>40) / (1 << 24)
+}
+
+// how many lines a kept line stands for, so downstream tools can extrapolate counts
+func sampleWeight(rate float32) string {
+	return strconv.FormatFloat(float64(1/rate), 'g', -1, 32)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sample", func() {
+	// send numbered lines and return the numbers that were kept
+	kept := func(config string, lines ...string) (numbers []string) {
+		withConfig(config, func() {
+			output := parse(strings.Join(lines, "\n"))
+			for _, line := range strings.Split(output, "\n") {
+				if line != "" {
+					numbers = append(numbers, line)
+				}
+			}
+		})
+		return
+	}
+
+	requests := func(times int) (lines []string) {
+		for i := 0; i < times; i++ {
+			for j := 0; j < 100; j++ {
+				lines = append(lines, fmt.Sprintf("request r%d", j))
+			}
+		}
+		return
+	}
+
+	It("keeps all or no lines with the same key", func() {
+		output := kept("---\npatterns:\n- regex: 'request (?P<id>\\S+)'\n  sampleRate: 0.5\n  sampleBy: id", requests(2)...)
+		Expect(len(output)).To(BeNumerically(">", 40))
+		Expect(len(output)).To(BeNumerically("<", 160))
+		Expect(output[:len(output)/2]).To(Equal(output[len(output)/2:]))
+	})
+
+	It("keeps lines without the key like lines with an empty key", func() {
+		Expect(sampleHash("")).To(BeNumerically("~", 0.9247, 0.0001)) // above the rate, so all are dropped
+		output := kept("---\npatterns:\n- regex: 'request'\n  sampleRate: 0.5\n  sampleBy: id", requests(1)...)
+		Expect(output).To(BeEmpty())
+		output = kept("---\npatterns:\n- regex: 'request'\n  sampleRate: 0.95\n  sampleBy: id", requests(1)...)
+		Expect(output).To(HaveLen(100))
+	})
+
+	It("distributes keys evenly", func() {
+		count := 0
+		for i := 0; i < 10000; i++ {
+			if sampleHash(fmt.Sprint(i)) < 0.3 {
+				count++
+			}
+		}
+		Expect(count).To(BeNumerically("~", 3000, 200))
+	})
+
+	It("is reproducible with a seed", func() {
+		config := "---\nsampleSeed: 123\npatterns:\n- regex: 'request'\n  sampleRate: 0.5"
+		first := kept(config, requests(1)...)
+		Expect(len(first)).To(BeNumerically(">", 20))
+		Expect(len(first)).To(BeNumerically("<", 80))
+		Expect(kept(config, requests(1)...)).To(Equal(first))
+	})
+
+	It("adds the weight of kept lines", func() {
+		Expect(kept("---\nsampleKey: weight\npatterns:\n- regex: hi\n  sampleRate: 1", "hi", "ho")).To(Equal([]string{
+			`{"message":"hi","weight":1}`,
+			`{"message":"ho"}`,
+		}))
+		Expect(sampleWeight(0.01)).To(Equal("100"))
+		Expect(sampleWeight(0.3)).To(Equal("3.3333333"))
+	})
+
+	It("can count lines that were sampled out", func() {
+		port := randomPort()
+		withConfig("---\nsampleKey: weight\nprometheus:\n  port: "+port+"\npatterns:\n- regex: hi\n  sampleRate: 0\n  countSampled: true\n  add: {pattern: hi}", func() {
+			Expect(prometheusMetrics(port)).To(ContainSubstring("logs_total{pattern=\"hi\"} 1\n"))
+		})
+	})
+
+	It("does not output lines that were sampled out but counted", func() {
+		withConfig("---\noutput:\n  loki:\n    url: http://localhost:1\npatterns:\n- regex: hi\n  sampleRate: 0\n  countSampled: true", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			processed := prepareLine(StreamLine{line: "hi"}, config)
+			Expect(processed.out).To(BeNil())
+			Expect(processed.entry).To(BeNil())
+			Expect(processed.labels).To(Equal(map[string]string{}))
+		})
+	})
+
+	It("fails on sampleBy without sampleRate", func() {
+		withConfig("---\npatterns:\n- regex: hi\n  sampleBy: id", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("patterns[0].sampleBy needs sampleRate to be set"))
+		})
+	})
+
+	It("fails on countSampled without sampleRate", func() {
+		withConfig("---\npatterns:\n- regex: hi\n  countSampled: true", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("patterns[0].countSampled needs sampleRate to be set"))
+		})
+	})
+})
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const maxBackoff = 10 * time.Second
+
+const connectionTimeout = 5 * time.Second
+
+var connectionBackoff = 100 * time.Millisecond
+
+// how long stopping may retry delivering what was written before giving up and dropping it
+var shutdownTimeout = 5 * time.Second
+
+var connectionSchemes = []string{"udp", "tcp", "tls"}
+
+// a processed log handed to sinks, must not be modified since sinks process it asynchronously
+type Entry struct {
+	time   time.Time
+	level  string
+	log    *OrderedMap       // everything that was logged
+	line   string            // log serialized in the output format
+	labels map[string]string // what metrics are labeled with
+}
+
+// outputs that drop logs when they cannot keep up
+type dropper interface {
+	Name() string
+	Dropped() *atomic.Uint64
+}
+
+// receives every log in addition to stdout/stderr, must never block
+type Sink interface {
+	dropper
+	Start()
+	Stop() // deliver everything that was written
+	Write(entry *Entry)
+}
+
+// sinks that write to files that can be moved away by logrotate, or files that can be reloaded
+type reopener interface {
+	Reopen()
+}
+
+// buffers entries in memory and hands them to the sink in batches from a single goroutine
+// so a slow receiver never blocks processing, entries are dropped when the buffer is full
+type batcher struct {
+	entries  chan *Entry
+	stopping chan struct{} // closed when shutdown takes too long so retries give up
+	done     chan struct{}
+	dropped  atomic.Uint64
+}
+
+// add returns true when the batch is full, flush delivers the batch
+func (b *batcher) start(bufferSize int, wait time.Duration, add func(*Entry) bool, flush func()) {
+	b.entries = make(chan *Entry, bufferSize)
+	b.stopping = make(chan struct{})
+	b.done = make(chan struct{})
+
+	go func() {
+		defer close(b.done)
+		ticker := time.NewTicker(wait)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case entry, open := <-b.entries:
+				if !open {
+					flush()
+					return
+				}
+				if add(entry) {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+}
+
+// flush everything that was written
+func (b *batcher) stop() {
+	close(b.entries)
+	awaitShutdown(b.done, b.stopping)
+}
+
+// wait for done, but tell retries to give up when it takes too long so a dead receiver cannot block shutdown
+func awaitShutdown(done <-chan struct{}, stopping chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(shutdownTimeout):
+		close(stopping)
+		<-done
+	}
+}
+
+func (b *batcher) write(entry *Entry) {
+	select {
+	case b.entries <- entry:
+	default:
+		b.dropped.Add(1)
+	}
+}
+
+// send until it succeeds, failed in a way that is not worth retrying or stop is closed, returns the last result
+func sendWithRetries(maxRetries int, base time.Duration, stop <-chan struct{}, send func() (int, []byte, error)) (int, []byte, error) {
+	for attempt := 0; ; attempt++ {
+		status, body, err := send()
+		success := err == nil && status/100 == 2
+		retryable := err != nil || status == http.StatusTooManyRequests || status >= 500
+		if success || !retryable || attempt >= maxRetries {
+			return status, body, err
+		}
+		select {
+		case <-time.After(backoff(base, maxBackoff, attempt)):
+		case <-stop:
+			return status, body, err
+		}
+	}
+}
+
+func post(client *http.Client, url string, headers map[string]string, body []byte) (int, []byte, error) {
+	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	check(err) // url was validated on startup
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	content, err := io.ReadAll(response.Body)
+	return response.StatusCode, content, err
+}
+
+// time to wait before retrying the nth time, doubling until the max
+func backoff(base time.Duration, max time.Duration, attempt int) time.Duration {
+	wait := base << attempt
+	if wait > max || wait <= 0 {
+		return max
+	}
+	return wait
+}
+
+// connection to a log receiver that is reestablished after failures,
+// writes fail fast while waiting to reconnect so a dead receiver only leads to drops
+type connection struct {
+	network   string // udp, tcp or tls
+	address   string
+	tlsConfig *tls.Config
+	conn      net.Conn
+	failures  int
+	retryAt   time.Time
+}
+
+// address looks like udp://host:port
+func newConnection(address string, location string) (*connection, error) {
+	network, hostPort, found := strings.Cut(address, "://")
+	if !found || !contains(connectionSchemes, network) {
+		return nil, fmt.Errorf("%s must start with udp://, tcp:// or tls:// but was %s", location, address)
+	}
+	return &connection{network: network, address: hostPort, tlsConfig: &tls.Config{}}, nil
+}
+
+func (c *connection) write(data []byte) (err error) {
+	// a stream can break while idle, which we only notice when writing, so retry once on a new connection
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			if time.Now().Before(c.retryAt) {
+				return fmt.Errorf("waiting to reconnect to %s", c.address)
+			}
+			if c.conn, err = c.dial(); err != nil {
+				c.failed()
+				return err
+			}
+		}
+
+		_ = c.conn.SetWriteDeadline(time.Now().Add(connectionTimeout))
+		if _, err = c.conn.Write(data); err == nil {
+			c.failures = 0
+			return nil
+		}
+		c.close()
+	}
+	c.failed() // untested section
+	return err
+}
+
+func (c *connection) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: connectionTimeout}
+	if c.network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", c.address, c.tlsConfig)
+	}
+	return dialer.Dial(c.network, c.address)
+}
+
+func (c *connection) failed() {
+	c.retryAt = time.Now().Add(backoff(connectionBackoff, maxBackoff, c.failures))
+	c.failures++
+}
+
+func (c *connection) close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
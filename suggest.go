@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const suggestSamples = 100 // lines per template to decide what its variable parts look like
+
+// what variable parts of a template can be captured as, checked in order, the first that matches all samples wins
+var suggestCaptures = []struct {
+	name  string
+	regex string
+	match *regexp.Regexp
+}{
+	{"ip", `\d{1,3}(?:\.\d{1,3}){3}`, regexp.MustCompile(`^\d{1,3}(?:\.\d{1,3}){3}$`)},
+	{"duration", `\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h)`, regexp.MustCompile(`^\d+(?:\.\d+)?(?:ns|us|µs|ms|s|m|h)$`)},
+	{"number", `-?\d+(?:\.\d+)?`, regexp.MustCompile(`^-?\d+(?:\.\d+)?$`)},
+	{"host", `[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)+`, regexp.MustCompile(`^[a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)+$`)},
+}
+
+// words that make a line worth keeping even when it is frequent
+var suggestImportant = regexp.MustCompile(`(?i)error|fail|fatal|panic|exception|warn|timeout|refused|denied`)
+
+type suggestion struct {
+	template *template
+	samples  [][]string // tokens of the first lines, to see what variable parts look like
+}
+
+// `logrecycler suggest sample.log` prints a draft `patterns:` config for the lines in the file
+func suggest(args []string, out io.Writer) error {
+	set := flag.NewFlagSet("logrecycler suggest", flag.ContinueOnError)
+	set.SetOutput(out)
+	similarity := set.Float64("similarity", 0.4, "Share of equal tokens for lines to share a pattern")
+	maxPatterns := set.Int("max", 50, "Most frequent patterns to suggest")
+	discardShare := set.Float64("discard", 0.2, "Suggest discarding patterns with more than this share of lines that do not look like problems")
+	if err := set.Parse(args); err != nil {
+		return err
+	}
+	if len(set.Args()) != 1 {
+		return fmt.Errorf("usage: logrecycler suggest [flags] sample.log")
+	}
+	path := set.Arg(0)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// cluster the lines
+	d := newDrain(*similarity, math.MaxInt)
+	suggestions := map[*template]*suggestion{}
+	total := 0
+	glog := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := glogRegex.FindString(line); match != "" {
+			line = line[len(match):]
+			glog = true
+		}
+		total++
+		template := d.add(line)
+		s, found := suggestions[template]
+		if !found {
+			s = &suggestion{template: template}
+			suggestions[template] = s
+		}
+		if len(s.samples) < suggestSamples {
+			s.samples = append(s.samples, strings.Fields(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err // untested section
+	}
+
+	// most frequent first, so the most common lines need the least regex work
+	_, _ = fmt.Fprintf(out, "# draft from %d lines of %s, review before using\n", total, path)
+	if glog {
+		_, _ = fmt.Fprintln(out, "glog: simple # lines had a glog prefix")
+	}
+	_, _ = fmt.Fprintln(out, "patterns:")
+	names := map[string]int{}
+	for _, template := range d.top(*maxPatterns) {
+		s := suggestions[template]
+		share := float64(template.count) / float64(total)
+		regex, captures := s.regex()
+		name := uniqueName(names, s.name())
+
+		_, _ = fmt.Fprintf(out, "# %s of lines like: %s\n", formatShare(share), template.sample)
+		_, _ = fmt.Fprintf(out, "- regex: %s\n", yamlQuote(regex))
+		_, _ = fmt.Fprintf(out, "  add: {pattern: %s}\n", name)
+		if len(captures) != 0 {
+			_, _ = fmt.Fprintf(out, "  ignoreMetricLabels: [%s] # captures are often too many different values for metrics\n", strings.Join(captures, ", "))
+		}
+		if share > *discardShare && !suggestImportant.MatchString(template.String()) {
+			_, _ = fmt.Fprintln(out, "  discard: true # frequent and does not look like a problem")
+		}
+	}
+	return nil
+}
+
+// anchored regex with a named capture for every variable part that looks like a known kind of value
+func (s *suggestion) regex() (regex string, captures []string) {
+	parts := make([]string, len(s.template.tokens))
+	used := map[string]int{}
+	for i, token := range s.template.tokens {
+		if token != templateParam {
+			parts[i] = regexp.QuoteMeta(token)
+			continue
+		}
+		parts[i] = `\S+`
+		for _, capture := range suggestCaptures {
+			if s.all(i, capture.match) {
+				name := uniqueName(used, capture.name)
+				captures = append(captures, name)
+				parts[i] = "(?P<" + name + ">" + capture.regex + ")"
+				break
+			}
+		}
+	}
+	return `^\s*` + strings.Join(parts, `\s+`) + `\s*$`, captures // tokens do not include surrounding whitespace
+}
+
+// if the token at this position matches in all samples
+func (s *suggestion) all(position int, match *regexp.Regexp) bool {
+	for _, tokens := range s.samples {
+		if !match.MatchString(tokens[position]) {
+			return false
+		}
+	}
+	return true
+}
+
+// first words of the template like `connecting-to-failed`
+func (s *suggestion) name() string {
+	var words []string
+	for _, token := range s.template.tokens {
+		word := strings.ToLower(strings.TrimFunc(token, func(r rune) bool { return !unicode.IsLetter(r) }))
+		if token == templateParam || word == "" || strings.IndexFunc(word, func(r rune) bool { return !unicode.IsLetter(r) && r != '-' && r != '_' }) != -1 {
+			continue
+		}
+		words = append(words, word)
+		if len(words) == 3 {
+			break
+		}
+	}
+	if len(words) == 0 {
+		return "unknown"
+	}
+	return strings.Join(words, "-")
+}
+
+// name, name2, name3 ...
+func uniqueName(used map[string]int, name string) string {
+	used[name]++
+	if used[name] == 1 {
+		return name
+	}
+	return name + strconv.Itoa(used[name])
+}
+
+func formatShare(share float64) string {
+	return strconv.FormatFloat(share*100, 'f', 1, 64) + "%"
+}
+
+// single quoted yaml string, so regex backslashes stay as they are
+func yamlQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
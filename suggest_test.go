@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("suggest", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "logrecycler")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	sample := func(lines ...string) string {
+		path := filepath.Join(dir, "sample.log")
+		Expect(os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644)).To(BeNil())
+		return path
+	}
+
+	It("suggests patterns with captures for the most frequent lines", func() {
+		path := sample(
+			"health check ok",
+			"connecting to 10.0.0.1 failed after 3 retries in 1.5s",
+			"health check ok",
+			"connecting to 10.0.0.2 failed after 12 retries in 20ms",
+			"health check ok",
+			"user alice logged in from example.com",
+			"health check ok",
+			"user bob logged in from mail.example.com",
+			"it's done",
+		)
+		Expect(runWithArgs("suggest", path)).To(Equal(
+			"# draft from 9 lines of " + path + ", review before using\n" +
+				"patterns:\n" +
+				"# 44.4% of lines like: health check ok\n" +
+				"- regex: '^\\s*health\\s+check\\s+ok\\s*$'\n" +
+				"  add: {pattern: health-check-ok}\n" +
+				"  discard: true # frequent and does not look like a problem\n" +
+				"# 22.2% of lines like: connecting to 10.0.0.1 failed after 3 retries in 1.5s\n" +
+				"- regex: '^\\s*connecting\\s+to\\s+(?P<ip>\\d{1,3}(?:\\.\\d{1,3}){3})\\s+failed\\s+after\\s+(?P<number>-?\\d+(?:\\.\\d+)?)\\s+retries\\s+in\\s+(?P<duration>\\d+(?:\\.\\d+)?(?:ns|us|µs|ms|s|m|h))\\s*$'\n" +
+				"  add: {pattern: connecting-to-failed}\n" +
+				"  ignoreMetricLabels: [ip, number, duration] # captures are often too many different values for metrics\n" +
+				"# 22.2% of lines like: user alice logged in from example.com\n" +
+				"- regex: '^\\s*user\\s+\\S+\\s+logged\\s+in\\s+from\\s+(?P<host>[a-zA-Z0-9-]+(?:\\.[a-zA-Z0-9-]+)+)\\s*$'\n" +
+				"  add: {pattern: user-logged-in}\n" +
+				"  ignoreMetricLabels: [host] # captures are often too many different values for metrics\n" +
+				"  discard: true # frequent and does not look like a problem\n" +
+				"# 11.1% of lines like: it's done\n" +
+				"- regex: '^\\s*it''s\\s+done\\s*$'\n" +
+				"  add: {pattern: done}",
+		))
+	})
+
+	It("suggests config that captures values", func() {
+		path := sample(
+			"I0530 10:13:00.740596      33 foo.go:132] took 1ms to connect to db.local port 5432",
+			"W0530 10:13:01.740596      33 foo.go:132] took 2s to connect to db.local port 5432",
+			"took 3s to connect to db.local port 5432",
+		)
+		config := runWithArgs("suggest", "-discard", "1", "-max", "1", path)
+		Expect(config).To(ContainSubstring("glog: simple"))
+		withConfig(config, func() {
+			Expect(parse("W0530 10:13:01.740596      33 foo.go:132] took 2s to connect to db.local port 5432")).To(Equal(
+				"{\"message\":\"took 2s to connect to db.local port 5432\",\"duration\":\"2s\",\"number\":\"5432\",\"pattern\":\"took-to-connect\"}",
+			))
+		})
+	})
+
+	It("suggests regexes that match their own sample lines", func() {
+		lines := []string{"  indented line 1", "indented line 2\t", "trailing space ", " both ", "user 10.0.0.1 took 5ms", "user 10.0.0.2 took 6ms"}
+		out := &bytes.Buffer{}
+		Expect(suggest([]string{"-discard", "1", sample(lines...)}, out)).To(BeNil())
+		var regexes []*regexp.Regexp
+		for _, line := range strings.Split(out.String(), "\n") {
+			if quoted, found := strings.CutPrefix(line, "- regex: "); found {
+				regexes = append(regexes, regexp.MustCompile(strings.ReplaceAll(quoted[1:len(quoted)-1], "''", "'")))
+			}
+		}
+		Expect(regexes).To(HaveLen(4))
+		for _, line := range lines {
+			matched := false
+			for _, regex := range regexes {
+				matched = matched || regex.MatchString(line)
+			}
+			Expect(matched).To(BeTrue(), line)
+		}
+	})
+
+	It("names patterns without words and with the same words uniquely", func() {
+		out := &bytes.Buffer{}
+		Expect(suggest([]string{"-similarity", "1", sample("1 2", "a 1", "a 2 3")}, out)).To(BeNil())
+		Expect(out.String()).To(ContainSubstring("add: {pattern: unknown}\n"))
+		Expect(out.String()).To(ContainSubstring("add: {pattern: a}\n"))
+		Expect(out.String()).To(ContainSubstring("add: {pattern: a2}\n"))
+	})
+
+	It("fails without a file", func() {
+		Expect(suggest([]string{}, &bytes.Buffer{}).Error()).To(Equal("usage: logrecycler suggest [flags] sample.log"))
+		Expect(suggest([]string{filepath.Join(dir, "missing.log")}, &bytes.Buffer{})).ToNot(BeNil())
+		Expect(suggest([]string{"-nope"}, &bytes.Buffer{})).ToNot(BeNil())
+	})
+})
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog severity to level, emergency/alert/critical are all fatal and notice is info
+var syslogLevels = []string{"FATAL", "FATAL", "FATAL", "ERROR", "WARN", "INFO", "INFO", "DEBUG"}
+
+const maxSyslogMessage = 64 * 1024
+
+var maxSyslogFrameDigits = len(strconv.Itoa(maxSyslogMessage))
+
+const (
+	syslogHostnameKey = "hostname"
+	syslogAppNameKey  = "app_name"
+	syslogProcidKey   = "procid"
+)
+
+// receives syslog messages over udp/tcp/unix sockets
+type SyslogServer struct {
+	Address  string // for example udp://0.0.0.0:514 tcp://0.0.0.0:601 unix:///tmp/log.sock unixgram:///dev/log
+	packet   net.PacketConn
+	listener net.Listener
+	conns    map[net.Conn]bool
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// start listening right away so we fail early when the address is unusable
+func NewSyslogServer(address string) (*SyslogServer, error) {
+	s := &SyslogServer{Address: address, conns: map[net.Conn]bool{}, stop: make(chan struct{})}
+
+	network, path, found := strings.Cut(address, "://")
+	if !found {
+		return nil, fmt.Errorf("syslog address must look like udp://host:port but was %s", address)
+	}
+
+	var err error
+	switch network {
+	case "udp", "unixgram":
+		s.packet, err = net.ListenPacket(network, path)
+	case "tcp", "unix":
+		s.listener, err = net.Listen(network, path)
+	default:
+		return nil, fmt.Errorf("syslog network must be one of udp/tcp/unix/unixgram but was %s", network)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// the actual address in case it was a random port
+func (s *SyslogServer) Addr() net.Addr {
+	if s.packet != nil {
+		return s.packet.LocalAddr()
+	}
+	return s.listener.Addr()
+}
+
+// send all received messages until stopped
+func (s *SyslogServer) Follow(lines chan<- StreamLine) {
+	s.wg.Add(1)
+	if s.packet != nil {
+		go s.receivePackets(lines)
+	} else {
+		go s.accept(lines)
+	}
+
+	<-s.stop
+	if s.packet != nil {
+		_ = s.packet.Close()
+	} else {
+		_ = s.listener.Close()
+		s.mutex.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mutex.Unlock()
+	}
+	s.wg.Wait()
+
+	if strings.HasPrefix(s.Address, "unix") {
+		_ = os.Remove(s.Addr().String())
+	}
+}
+
+func (s *SyslogServer) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// every packet is a single message
+func (s *SyslogServer) receivePackets(lines chan<- StreamLine) {
+	defer s.wg.Done()
+	buf := make([]byte, maxSyslogMessage)
+	for {
+		n, _, err := s.packet.ReadFrom(buf)
+		if err != nil {
+			return // closed
+		}
+		lines <- parseSyslog(strings.TrimRight(string(buf[:n]), "\r\n"))
+	}
+}
+
+func (s *SyslogServer) accept(lines chan<- StreamLine) {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return // closed
+		}
+
+		s.mutex.Lock()
+		s.conns[conn] = true
+		s.mutex.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.receiveStream(conn, lines)
+			s.mutex.Lock()
+			delete(s.conns, conn)
+			s.mutex.Unlock()
+			_ = conn.Close()
+		}()
+	}
+}
+
+// streams use octet-counting (`<length> <message>`) or newline framing, see RFC6587
+func (s *SyslogServer) receiveStream(conn net.Conn, lines chan<- StreamLine) {
+	reader := bufio.NewReader(conn)
+	for {
+		message, err := readSyslogFrame(reader)
+		if message != "" {
+			lines <- parseSyslog(message)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func readSyslogFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] >= '1' && first[0] <= '9' {
+		// read the length digit by digit so a peer sending digits forever cannot grow memory
+		var length []byte
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			if b == ' ' {
+				break
+			}
+			if len(length) == maxSyslogFrameDigits {
+				return "", fmt.Errorf("invalid syslog frame length %q", length)
+			}
+			length = append(length, b)
+		}
+		size, err := strconv.Atoi(string(length))
+		if err != nil || size > maxSyslogMessage {
+			return "", fmt.Errorf("invalid syslog frame length %q", length)
+		}
+		message := make([]byte, size)
+		_, err = io.ReadFull(reader, message)
+		return string(message), err
+	}
+
+	// read in chunks so a peer that never sends a newline cannot grow memory
+	var message []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(message)+len(chunk) > maxSyslogMessage {
+			return "", fmt.Errorf("syslog message longer than %d bytes", maxSyslogMessage)
+		}
+		message = append(message, chunk...)
+		if err != bufio.ErrBufferFull {
+			return strings.TrimRight(string(message), "\r\n"), err
+		}
+	}
+}
+
+// parse RFC5424 or RFC3164 message, falling back to the plain message when it is neither
+func parseSyslog(message string) StreamLine {
+	line := StreamLine{index: 0, line: message}
+
+	// <PRI>
+	if len(message) < 3 || message[0] != '<' {
+		return line
+	}
+	end := strings.IndexByte(message, '>')
+	if end < 2 || end > 4 {
+		return line
+	}
+	pri, err := strconv.Atoi(message[1:end])
+	if err != nil || pri > 191 {
+		return line
+	}
+	line.level = syslogLevels[pri%8]
+	rest := message[end+1:]
+
+	if strings.HasPrefix(rest, "1 ") {
+		parseRFC5424(rest[2:], &line)
+	} else {
+		parseRFC3164(rest, &line)
+	}
+	return line
+}
+
+// TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func parseRFC5424(rest string, line *StreamLine) {
+	parts := strings.SplitN(rest, " ", 6)
+	if len(parts) < 6 {
+		line.line = rest
+		return
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, parts[0]); err == nil {
+		line.time = t
+	}
+	line.fields = NewOrderedMap()
+	for i, key := range []string{syslogHostnameKey, syslogAppNameKey, syslogProcidKey} {
+		if value := parts[i+1]; value != "-" {
+			line.fields.Set(key, value)
+		}
+	}
+
+	message := skipStructuredData(parts[5])
+	message = strings.TrimPrefix(message, " ")
+	line.line = strings.TrimPrefix(message, "\ufeff") // BOM
+}
+
+// `-` or any number of `[id key="value"]` that can contain escaped `]`
+func skipStructuredData(data string) string {
+	if strings.HasPrefix(data, "-") {
+		return data[1:]
+	}
+	for strings.HasPrefix(data, "[") {
+		i := 1
+		for ; i < len(data) && data[i] != ']'; i++ {
+			if data[i] == '\\' {
+				i++
+			}
+		}
+		if i >= len(data) {
+			return ""
+		}
+		data = data[i+1:]
+	}
+	return data
+}
+
+// Mmm dd hh:mm:ss [HOSTNAME] TAG[PID]: MSG
+func parseRFC3164(rest string, line *StreamLine) {
+	if len(rest) < len(time.Stamp)+1 {
+		line.line = rest
+		return
+	}
+	t, err := time.ParseInLocation(time.Stamp, rest[:len(time.Stamp)], time.Local) // has no zone, so it is the local time of the sender
+	if err != nil {
+		line.line = rest
+		return
+	}
+	line.time = t.AddDate(time.Now().Year(), 0, 0)
+	rest = strings.TrimPrefix(rest[len(time.Stamp):], " ")
+	line.fields = NewOrderedMap()
+
+	// hostname is optional, for example when sent locally via /dev/log
+	if space := strings.IndexByte(rest, ' '); space != -1 && !strings.ContainsAny(rest[:space], "[:") {
+		line.fields.Set(syslogHostnameKey, rest[:space])
+		rest = rest[space+1:]
+	}
+
+	// tag with optional pid
+	if colon := strings.Index(rest, ": "); colon != -1 && !strings.Contains(rest[:colon], " ") {
+		tag := rest[:colon]
+		if open := strings.IndexByte(tag, '['); open != -1 && strings.HasSuffix(tag, "]") {
+			line.fields.Set(syslogAppNameKey, tag[:open])
+			line.fields.Set(syslogProcidKey, tag[open+1:len(tag)-1])
+		} else {
+			line.fields.Set(syslogAppNameKey, tag)
+		}
+		rest = rest[colon+2:]
+	}
+
+	line.line = rest
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+
+// level to syslog severity, unknown levels are notice
+var syslogSeverities = map[string]int{
+	"TRACE": 7,
+	"DEBUG": 7,
+	"INFO":  6,
+	"WARN":  4,
+	"ERROR": 3,
+	"FATAL": 2,
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14, "solaris-cron": 15,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// forwards logs as RFC5424 syslog messages https://datatracker.ietf.org/doc/html/rfc5424
+type SyslogOutput struct {
+	Address          string // for example udp://rsyslog:514 tcp://rsyslog:601 tls://rsyslog:6514
+	Facility         string // default user
+	AppName          string `yaml:"appName"` // default logrecycler
+	Hostname         string // default hostname of the machine
+	StructuredDataId string `yaml:"structuredDataId"` // fields are sent as structured data with this id, default fields@32473
+	BufferSize       int    `yaml:"bufferSize"`       // logs to keep in memory while the receiver is slow, default 10000
+	facility         int
+	connection       *connection
+	batcher          batcher
+	timestampKey     string
+	levelKey         string
+	messageKey       string
+}
+
+func (s *SyslogOutput) setup(config *Config) (err error) {
+	if s.connection, err = newConnection(s.Address, "output.syslog.address"); err != nil {
+		return err
+	}
+	if s.Facility == "" {
+		s.Facility = "user"
+	}
+	facility, found := syslogFacilities[s.Facility]
+	if !found {
+		return fmt.Errorf("output.syslog.facility must be a syslog facility like user or local0 but was %s", s.Facility)
+	}
+	s.facility = facility
+	if s.AppName == "" {
+		s.AppName = "logrecycler"
+	}
+	if s.Hostname == "" {
+		s.Hostname = defaultHostname()
+	}
+	if s.StructuredDataId == "" {
+		s.StructuredDataId = "fields@32473"
+	}
+	if s.BufferSize == 0 {
+		s.BufferSize = 10000
+	}
+	s.timestampKey = config.TimestampKey
+	s.levelKey = config.LevelKey
+	s.messageKey = config.MessageKey
+	return nil
+}
+
+func (s *SyslogOutput) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogOutput) Start() {
+	s.batcher.start(s.BufferSize, time.Second, s.add, func() {})
+}
+
+func (s *SyslogOutput) Stop() {
+	s.batcher.stop()
+	s.connection.close()
+}
+
+func (s *SyslogOutput) Write(entry *Entry) {
+	s.batcher.write(entry)
+}
+
+func (s *SyslogOutput) Dropped() *atomic.Uint64 {
+	return &s.batcher.dropped
+}
+
+// send right away, streams use octet counting framing so messages can contain newlines
+func (s *SyslogOutput) add(entry *Entry) bool {
+	message := s.format(entry)
+	if s.connection.network != "udp" {
+		message = strconv.Itoa(len(message)) + " " + message
+	}
+	if err := s.connection.write([]byte(message)); err != nil {
+		s.batcher.dropped.Add(1)
+	}
+	return false
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID key="value"] MSG
+func (s *SyslogOutput) format(entry *Entry) string {
+	var b strings.Builder
+	b.WriteString("<" + strconv.Itoa(s.facility*8+syslogSeverity(entry.level)) + ">1 ")
+	b.WriteString(entry.time.Format(rfc5424Time) + " " + s.Hostname + " " + s.AppName + " - - ")
+
+	params := 0
+	for _, key := range entry.log.keys {
+		if key == s.messageKey || key == s.timestampKey || key == s.levelKey {
+			continue
+		}
+		if params == 0 {
+			b.WriteString("[" + s.StructuredDataId)
+		}
+		params++
+		b.WriteString(" " + syslogParamName(key) + `="` + syslogParamValue(entry.log.values[key]) + `"`)
+	}
+	if params == 0 {
+		b.WriteString("-")
+	} else {
+		b.WriteString("]")
+	}
+
+	if message := entry.log.values[s.messageKey]; message != "" {
+		b.WriteString(" " + message)
+	}
+	return b.String()
+}
+
+func syslogSeverity(level string) int {
+	if severity, found := syslogSeverities[strings.ToUpper(level)]; found {
+		return severity
+	}
+	return 5
+}
+
+// param names are limited to 32 printable characters without = ] " and space
+func syslogParamName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+func syslogParamValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+func defaultHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "-" // untested section
+	}
+	return hostname
+}
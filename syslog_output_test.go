@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("syslog output", func() {
+	BeforeEach(func() {
+		connectionBackoff = time.Millisecond
+	})
+
+	entry := func(level string, keys ...string) *Entry {
+		log := NewOrderedMap()
+		for i := 0; i < len(keys); i += 2 {
+			log.Set(keys[i], keys[i+1])
+		}
+		return &Entry{time: time.Date(2020, 1, 2, 3, 4, 5, 6000, time.UTC), level: level, log: log}
+	}
+
+	output := func(address string) *SyslogOutput {
+		s := &SyslogOutput{Address: address, Hostname: "host"}
+		Expect(s.setup(&Config{TimestampKey: "ts", LevelKey: "level", MessageKey: "message"})).To(BeNil())
+		return s
+	}
+
+	listenUdp := func() net.PacketConn {
+		packet, err := net.ListenPacket("udp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		Expect(packet.SetReadDeadline(time.Now().Add(time.Second))).To(BeNil())
+		return packet
+	}
+
+	readPacket := func(packet net.PacketConn) string {
+		buf := make([]byte, 64*1024)
+		n, _, err := packet.ReadFrom(buf)
+		Expect(err).To(BeNil())
+		return string(buf[:n])
+	}
+
+	It("formats fields as structured data", func() {
+		s := output("udp://127.0.0.1:514")
+		Expect(s.format(entry("ERROR", "ts", "x", "level", "ERROR", "message", "hi", "a b", `q"]\`, "pattern", "p"))).To(Equal(
+			`<11>1 2020-01-02T03:04:05.000006Z host logrecycler - - [fields@32473 a_b="q\"\]\\" pattern="p"] hi`,
+		))
+	})
+
+	It("formats without fields and message", func() {
+		s := output("udp://127.0.0.1:514")
+		s.Facility = "local0"
+		Expect(s.setup(&Config{MessageKey: "message"})).To(BeNil())
+		Expect(s.format(entry("NOTICE"))).To(Equal(`<133>1 2020-01-02T03:04:05.000006Z host logrecycler - - -`))
+	})
+
+	It("limits param names", func() {
+		Expect(syslogParamName("")).To(Equal("_"))
+		Expect(syslogParamName("ü=1234567890123456789012345678901234567890")).To(Equal("__123456789012345678901234567890"))
+	})
+
+	It("sends over udp", func() {
+		packet := listenUdp()
+		defer packet.Close()
+		s := output("udp://" + packet.LocalAddr().String())
+		s.Start()
+		s.Write(entry("INFO", "message", "hi"))
+		s.Stop()
+		Expect(readPacket(packet)).To(Equal("<14>1 2020-01-02T03:04:05.000006Z host logrecycler - - - hi"))
+		Expect(s.Dropped().Load()).To(Equal(uint64(0)))
+		Expect(s.Name()).To(Equal("syslog"))
+	})
+
+	It("sends over tcp with octet counting", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		defer listener.Close()
+
+		s := output("tcp://" + listener.Addr().String())
+		s.Start()
+		s.Write(entry("INFO", "message", "a\nb"))
+		s.Write(entry("WARN", "message", "c"))
+		s.Stop()
+
+		conn, err := listener.Accept()
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		Expect(readSyslogFrame(reader)).To(Equal("<14>1 2020-01-02T03:04:05.000006Z host logrecycler - - - a\nb"))
+		Expect(readSyslogFrame(reader)).To(Equal("<12>1 2020-01-02T03:04:05.000006Z host logrecycler - - - c"))
+	})
+
+	It("sends over tls", func() {
+		// borrow the test certificate of httptest
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		certificates := server.TLS.Certificates
+		roots := server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+		server.Close()
+
+		listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certificates})
+		Expect(err).To(BeNil())
+		defer listener.Close()
+		received := make(chan string)
+		go func() {
+			defer GinkgoRecover()
+			conn, err := listener.Accept()
+			Expect(err).To(BeNil())
+			defer conn.Close()
+			frame, _ := readSyslogFrame(bufio.NewReader(conn))
+			received <- frame
+		}()
+
+		s := output("tls://" + listener.Addr().String())
+		s.connection.tlsConfig.RootCAs = roots
+		s.Start()
+		s.Write(entry("INFO", "message", "hi"))
+		Expect(<-received).To(HaveSuffix(" hi"))
+		s.Stop()
+	})
+
+	It("drops while the receiver is down and reconnects", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		address := listener.Addr().String()
+		Expect(listener.Close()).To(BeNil())
+
+		s := output("tcp://" + address)
+		Expect(s.add(entry("INFO", "message", "a"))).To(BeFalse())
+		Expect(s.add(entry("INFO", "message", "b"))).To(BeFalse()) // waiting to reconnect
+		Expect(s.Dropped().Load()).To(Equal(uint64(2)))
+
+		listener, err = net.Listen("tcp", address)
+		Expect(err).To(BeNil())
+		defer listener.Close()
+		time.Sleep(10 * time.Millisecond)
+		s.add(entry("INFO", "message", "c"))
+		Expect(s.Dropped().Load()).To(Equal(uint64(2)))
+		s.connection.close()
+
+		conn, err := listener.Accept()
+		Expect(err).To(BeNil())
+		defer conn.Close()
+		Expect(readSyslogFrame(bufio.NewReader(conn))).To(HaveSuffix(" c"))
+	})
+
+	It("retries once on a new connection when the old one broke", func() {
+		packet := listenUdp()
+		defer packet.Close()
+		s := output("udp://" + packet.LocalAddr().String())
+		broken, err := net.Dial("udp", packet.LocalAddr().String())
+		Expect(err).To(BeNil())
+		Expect(broken.Close()).To(BeNil())
+		s.connection.conn = broken
+
+		s.add(entry("INFO", "message", "hi"))
+		Expect(readPacket(packet)).To(HaveSuffix(" hi"))
+		Expect(s.Dropped().Load()).To(Equal(uint64(0)))
+		s.connection.close()
+	})
+
+	It("forwards logs when configured", func() {
+		packet := listenUdp()
+		defer packet.Close()
+		withConfig("output:\n  syslog:\n    address: udp://"+packet.LocalAddr().String()+"\n    appName: app\n", func() {
+			Expect(runWithCommand("echo", "hi")).To(Equal(`{"message":"hi"}`))
+		})
+		Expect(readPacket(packet)).To(MatchRegexp(`^<13>1 \S+ \S+ app - - - hi$`))
+	})
+
+	It("fails on invalid address", func() {
+		withConfig("output:\n  syslog:\n    address: rsyslog:514", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.syslog.address must start with udp://, tcp:// or tls:// but was rsyslog:514"))
+		})
+	})
+
+	It("fails on invalid facility", func() {
+		withConfig("output:\n  syslog:\n    address: udp://rsyslog:514\n    facility: nope", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("output.syslog.facility must be a syslog facility like user or local0 but was nope"))
+		})
+	})
+})
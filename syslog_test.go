@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("syslog", func() {
+	Describe("parseSyslog", func() {
+		fields := func(kv ...string) *OrderedMap {
+			m := NewOrderedMap()
+			for i := 0; i < len(kv); i += 2 {
+				m.Set(kv[i], kv[i+1])
+			}
+			return m
+		}
+
+		It("parses RFC5424", func() {
+			line := parseSyslog(`<165>1 2003-10-11T22:14:15.003Z host.example.com evntslog 123 ID47 [exampleSDID@32473 iut="3" x="\]"] An application event`)
+			Expect(line.level).To(Equal("INFO"))
+			Expect(line.time).To(Equal(time.Date(2003, 10, 11, 22, 14, 15, 3000000, time.UTC)))
+			Expect(line.fields).To(Equal(fields("hostname", "host.example.com", "app_name", "evntslog", "procid", "123")))
+			Expect(line.line).To(Equal("An application event"))
+		})
+
+		It("parses RFC5424 without optional values", func() {
+			line := parseSyslog("<11>1 - - - - - - \ufeffhi")
+			Expect(line.level).To(Equal("ERROR"))
+			Expect(line.time.IsZero()).To(BeTrue())
+			Expect(line.fields).To(Equal(fields()))
+			Expect(line.line).To(Equal("hi"))
+		})
+
+		It("parses RFC5424 with broken structured data", func() {
+			Expect(parseSyslog("<11>1 - - - - - [oops").line).To(Equal(""))
+		})
+
+		It("keeps RFC5424 with too few parts", func() {
+			Expect(parseSyslog("<11>1 - hi").line).To(Equal("- hi"))
+		})
+
+		It("parses RFC3164", func() {
+			line := parseSyslog("<34>Oct 11 22:14:15 mymachine su[123]: 'su root' failed")
+			Expect(line.level).To(Equal("FATAL"))
+			Expect(line.time).To(Equal(time.Date(time.Now().Year(), 10, 11, 22, 14, 15, 0, time.Local)))
+			Expect(line.fields).To(Equal(fields("hostname", "mymachine", "app_name", "su", "procid", "123")))
+			Expect(line.line).To(Equal("'su root' failed"))
+		})
+
+		It("parses RFC3164 without hostname and pid", func() {
+			line := parseSyslog("<15>Oct  1 22:14:15 su: hi")
+			Expect(line.level).To(Equal("DEBUG"))
+			Expect(line.fields).To(Equal(fields("app_name", "su")))
+			Expect(line.line).To(Equal("hi"))
+		})
+
+		It("keeps RFC3164 with invalid time", func() {
+			line := parseSyslog("<12>Foo 11 22:14:15 hi there")
+			Expect(line.level).To(Equal("WARN"))
+			Expect(line.line).To(Equal("Foo 11 22:14:15 hi there"))
+		})
+
+		It("keeps RFC3164 that is too short", func() {
+			Expect(parseSyslog("<12>hi").line).To(Equal("hi"))
+		})
+
+		It("keeps messages without PRI", func() {
+			for _, message := range []string{"hi", "<>hi", "<12345>hi", "<a>hi", "<192>hi"} {
+				line := parseSyslog(message)
+				Expect(line.line).To(Equal(message))
+				Expect(line.level).To(Equal(""))
+			}
+		})
+	})
+
+	Describe("SyslogServer", func() {
+		// receive the lines sent by fn
+		receive := func(address string, fn func(addr net.Addr)) (received []string) {
+			server, err := NewSyslogServer(address)
+			Expect(err).To(BeNil())
+
+			lines := make(chan StreamLine)
+			done := make(chan bool)
+			go func() {
+				for l := range lines {
+					received = append(received, l.line)
+				}
+				done <- true
+			}()
+			go func() {
+				server.Follow(lines)
+				close(lines)
+			}()
+
+			fn(server.Addr())
+			time.Sleep(10 * time.Millisecond)
+			server.Stop()
+			<-done
+			return
+		}
+
+		send := func(network string, addr net.Addr, message string) {
+			conn, err := net.Dial(network, addr.String())
+			Expect(err).To(BeNil())
+			_, err = conn.Write([]byte(message))
+			Expect(err).To(BeNil())
+			Expect(conn.Close()).To(BeNil())
+		}
+
+		It("receives udp", func() {
+			Expect(receive("udp://127.0.0.1:0", func(addr net.Addr) {
+				send("udp", addr, "<13>1 - - - - - - hi\n")
+			})).To(Equal([]string{"hi"}))
+		})
+
+		It("receives tcp with newline and octet counting framing", func() {
+			Expect(receive("tcp://127.0.0.1:0", func(addr net.Addr) {
+				send("tcp", addr, "<13>1 - - - - - - hi\n21 <13>1 - - - - - - h\no<13>1 - - - - - - ho")
+			})).To(Equal([]string{"hi", "h\no", "ho"}))
+		})
+
+		It("stops on invalid octet counting framing", func() {
+			Expect(receive("tcp://127.0.0.1:0", func(addr net.Addr) {
+				send("tcp", addr, "99999999 hi\nho\n")
+			})).To(BeNil())
+		})
+
+		It("stops on incomplete octet counting framing", func() {
+			Expect(receive("tcp://127.0.0.1:0", func(addr net.Addr) {
+				send("tcp", addr, "12")
+			})).To(BeNil())
+		})
+
+		It("rejects octet counting frames that are too long", func() {
+			_, err := readSyslogFrame(bufio.NewReader(strings.NewReader("99999 hi")))
+			Expect(err.Error()).To(Equal(`invalid syslog frame length "99999"`))
+		})
+
+		It("rejects octet counting lengths with too many digits without reading them all", func() {
+			_, err := readSyslogFrame(bufio.NewReader(endless('1')))
+			Expect(err.Error()).To(Equal(`invalid syslog frame length "11111"`))
+		})
+
+		It("rejects newline framed messages that are too long without reading them all", func() {
+			_, err := readSyslogFrame(bufio.NewReader(endless('a')))
+			Expect(err.Error()).To(Equal("syslog message longer than 65536 bytes"))
+		})
+
+		It("reads newline framed messages longer than the read buffer", func() {
+			message, err := readSyslogFrame(bufio.NewReader(strings.NewReader(strings.Repeat("a", 5000) + "\r\nb")))
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(strings.Repeat("a", 5000)))
+		})
+
+		It("closes open connections when stopping", func() {
+			var conn net.Conn
+			Expect(receive("tcp://127.0.0.1:0", func(addr net.Addr) {
+				var err error
+				conn, err = net.Dial("tcp", addr.String())
+				Expect(err).To(BeNil())
+				_, err = conn.Write([]byte("hi\n"))
+				Expect(err).To(BeNil())
+			})).To(Equal([]string{"hi"}))
+			conn.Close()
+		})
+
+		It("receives on unix sockets and cleans them up", func() {
+			dir, err := os.MkdirTemp("", "logrecycler")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+			socket := filepath.Join(dir, "log.sock")
+
+			Expect(receive("unixgram://"+socket, func(addr net.Addr) {
+				send("unixgram", addr, "hi")
+			})).To(Equal([]string{"hi"}))
+			Expect(receive("unix://"+socket, func(addr net.Addr) {
+				send("unix", addr, "hi")
+			})).To(Equal([]string{"hi"}))
+			_, err = os.Stat(socket)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("fails on invalid address", func() {
+			_, err := NewSyslogServer("127.0.0.1:514")
+			Expect(err.Error()).To(Equal("syslog address must look like udp://host:port but was 127.0.0.1:514"))
+		})
+
+		It("fails on invalid network", func() {
+			_, err := NewSyslogServer("http://127.0.0.1:514")
+			Expect(err.Error()).To(Equal("syslog network must be one of udp/tcp/unix/unixgram but was http"))
+		})
+
+		It("fails on unusable address", func() {
+			_, err := NewSyslogServer("tcp://127.0.0.1:99999")
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("fails to build followers with unusable address", func() {
+			_, err := buildFollowers(&Config{Inputs: []Input{{Syslog: "tcp://127.0.0.1:99999"}}})
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	It("processes syslog lines", func() {
+		withConfig("---\nlevelKey: level\ntimestampKey: ts\npatterns:\n- regex: hi\n  add:\n    foo: bar", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			output := captureStdout(func() {
+				config.Output.Start()
+				processLine(parseSyslog("<11>1 2003-10-11T22:14:15Z host app 12 - - hi"), config)
+				config.Output.Stop()
+			})
+			Expect(output).To(Equal(`{"ts":"2003-10-11T22:14:15Z","level":"ERROR","message":"hi","hostname":"host","app_name":"app","procid":"12","foo":"bar"}` + "\n"))
+		})
+	})
+
+	It("reports syslog fields but not the procid", func() {
+		received := receiveUdp(func() {
+			withConfig("---\nstatsd:\n  address: 0.0.0.0:8125\n  metric: foo.logs", func() {
+				config, err := NewConfig("logrecycler.yaml")
+				Expect(err).To(BeNil())
+				config.Statsd.Start()
+				defer config.Statsd.Stop()
+				captureStdout(func() {
+					config.Output.Start()
+					processLine(parseSyslog("<11>1 - host - 12 - - hi"), config)
+					config.Output.Stop()
+				})
+			})
+		})
+		Expect(received).To(Equal("foo.logs:1|c|#hostname:host"))
+	})
+
+	It("has syslog labels", func() {
+		withConfig("---\ninputs:\n- syslog: udp://127.0.0.1:0", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			Expect(config.possibleLabels()).To(Equal([]string{"hostname", "app_name"}))
+		})
+	})
+
+	It("listens while reading stdin", func() {
+		withConfig("---\ninputs:\n- syslog: udp://127.0.0.1:0", func() {
+			Expect(parse("hi")).To(Equal(`{"message":"hi"}`))
+		})
+	})
+})
+
+// a peer that sends the same byte forever
+type endless byte
+
+func (e endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(e)
+	}
+	return len(p), nil
+}
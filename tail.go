@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var tailInterval = 250 * time.Millisecond
+
+// follows files like `tail -F`: new files matching the globs are picked up, renamed and truncated files are reopened
+type Tailer struct {
+	Inputs    []Input
+	Positions string // where to persist read offsets, empty to not persist
+	files     map[string]*tailedFile
+	rotated   []*tailedFile // renamed or removed, but the app might still be writing to them
+	offsets   map[string]filePosition
+	saved     []byte // last written positions, to not rewrite them when nothing changed
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type tailedFile struct {
+	path    string
+	inode   uint64
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	partial string
+	decoder *Decoder
+}
+
+type filePosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+func NewTailer(inputs []Input, positions string) *Tailer {
+	return &Tailer{
+		Inputs:    inputs,
+		Positions: positions,
+		files:     map[string]*tailedFile{},
+		offsets:   map[string]filePosition{},
+		stop:      make(chan struct{}),
+	}
+}
+
+// send all lines from the followed files until stopped
+func (t *Tailer) Follow(lines chan<- StreamLine) {
+	t.loadPositions()
+	t.discover(true)
+
+	ticker := time.NewTicker(tailInterval)
+	defer ticker.Stop()
+	for {
+		t.read(lines)
+		t.savePositions()
+
+		select {
+		case <-t.stop:
+			t.read(lines) // pick up what was written since the last tick
+			t.savePositions()
+			t.close()
+			return
+		case <-ticker.C:
+			t.discover(false)
+		}
+	}
+}
+
+func (t *Tailer) Stop() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// open all not yet followed files, existing files start at the end (or the persisted offset) and new files at the start
+func (t *Tailer) discover(initial bool) {
+	for _, input := range t.Inputs {
+		paths, _ := filepath.Glob(input.File) // only fails on bad patterns, which then never match
+		sort.Strings(paths)
+		for _, path := range paths {
+			if _, followed := t.files[path]; followed {
+				continue
+			}
+			t.open(path, input.Format, initial)
+		}
+	}
+}
+
+func (t *Tailer) open(path string, format string, initial bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return // untested section
+	}
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		_ = file.Close()
+		return
+	}
+
+	// renamed to a path that also matches: keep following it at its offset instead of reading it again
+	ino := inode(stat)
+	if ino != 0 && t.adopt(path, ino) {
+		_ = file.Close()
+		return
+	}
+
+	var offset int64
+	if position, found := t.offsets[path]; found && position.Inode == ino && position.Offset <= stat.Size() {
+		offset = position.Offset
+	} else if initial {
+		offset = stat.Size()
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close() // untested section
+		return
+	}
+
+	t.files[path] = &tailedFile{path: path, inode: ino, file: file, reader: bufio.NewReader(file), offset: offset, decoder: NewDecoder(format)}
+}
+
+// if the file is already followed, rotated files continue to be followed under their new path
+func (t *Tailer) adopt(path string, ino uint64) bool {
+	for _, f := range t.files {
+		if f.inode == ino {
+			return true // renamed, but not noticed yet
+		}
+	}
+	for i, f := range t.rotated {
+		if f.inode == ino {
+			t.rotated = append(t.rotated[:i], t.rotated[i+1:]...)
+			f.path = path
+			t.files[path] = f
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tailer) read(lines chan<- StreamLine) {
+	t.drain(lines)
+
+	for path, f := range t.files {
+		f.readLines(lines)
+
+		// truncated: start over
+		stat, err := f.file.Stat()
+		if err == nil && stat.Size() < f.offset {
+			if _, err = f.file.Seek(0, io.SeekStart); err == nil {
+				f.reader.Reset(f.file)
+				f.offset = 0
+				f.partial = ""
+				f.readLines(lines)
+			}
+		}
+
+		// renamed or removed: keep draining the old file, the new file is picked up by discover
+		current, err := os.Stat(path)
+		if err != nil || stat == nil || !os.SameFile(stat, current) {
+			delete(t.files, path)
+			delete(t.offsets, path)
+			t.rotated = append(t.rotated, f)
+		}
+	}
+}
+
+// read what the app still writes to rotated files, like `tail -F` they are closed
+// once the new file exists (or the old was deleted) and nothing more was written
+func (t *Tailer) drain(lines chan<- StreamLine) {
+	kept := t.rotated[:0]
+	for _, f := range t.rotated {
+		_, err := os.Stat(f.path)
+		replaced := err == nil
+		stat, err := f.file.Stat()
+		deleted := err != nil || links(stat) == 0
+		if f.readLines(lines) || !(replaced || deleted) {
+			kept = append(kept, f)
+			continue
+		}
+		f.flushPartial(lines)
+		_ = f.file.Close()
+	}
+	t.rotated = kept
+}
+
+func (t *Tailer) close() {
+	for _, f := range t.files {
+		_ = f.file.Close()
+	}
+	for _, f := range t.rotated {
+		_ = f.file.Close()
+	}
+}
+
+// returns if anything was read
+func (f *tailedFile) readLines(lines chan<- StreamLine) (read bool) {
+	for {
+		chunk, err := f.reader.ReadString('\n')
+		f.offset += int64(len(chunk))
+		read = read || chunk != ""
+		if err != nil {
+			f.partial += chunk // wait for the rest of the line to be written
+			return read
+		}
+		line := f.partial + chunk[:len(chunk)-1]
+		f.partial = ""
+		f.send(line, lines)
+	}
+}
+
+func (f *tailedFile) flushPartial(lines chan<- StreamLine) {
+	if f.partial != "" {
+		f.send(f.partial, lines)
+		f.partial = ""
+	}
+}
+
+func (f *tailedFile) send(line string, lines chan<- StreamLine) {
+	if decoded, complete := f.decoder.Decode(StreamLine{index: 0, line: line, file: f.path}); complete {
+		lines <- decoded
+	}
+}
+
+func (t *Tailer) loadPositions() {
+	if t.Positions == "" {
+		return
+	}
+	content, err := os.ReadFile(t.Positions)
+	if err != nil {
+		return // first start
+	}
+	_ = json.Unmarshal(content, &t.offsets) // start fresh when corrupted
+}
+
+func (t *Tailer) savePositions() {
+	if t.Positions == "" {
+		return
+	}
+	for path, f := range t.files {
+		stat, err := f.file.Stat()
+		if err != nil {
+			continue // untested section
+		}
+		t.offsets[path] = filePosition{Inode: inode(stat), Offset: f.offset - int64(len(f.partial))}
+	}
+	content, err := json.Marshal(t.offsets)
+	check(err)
+	if bytes.Equal(content, t.saved) {
+		return // nothing was read
+	}
+
+	// write + rename so a crash never leaves a half written file
+	tmp := t.Positions + ".tmp"
+	if err = os.WriteFile(tmp, content, 0644); err != nil {
+		return // untested section
+	}
+	if os.Rename(tmp, t.Positions) == nil {
+		t.saved = content
+	}
+}
+
+func inode(stat os.FileInfo) uint64 {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Ino)
+	}
+	return 0 // untested section
+}
+
+func links(stat os.FileInfo) uint64 {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Nlink)
+	}
+	return 1 // untested section
+}
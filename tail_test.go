@@ -0,0 +1,244 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("tail", func() {
+	var dir string
+	var oldInterval time.Duration
+
+	BeforeEach(func() {
+		oldInterval = tailInterval
+		tailInterval = time.Millisecond
+		var err error
+		dir, err = os.MkdirTemp("", "logrecycler")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		tailInterval = oldInterval
+		os.RemoveAll(dir)
+	})
+
+	write := func(name string, content string) {
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		Expect(err).To(BeNil())
+		_, err = file.WriteString(content)
+		Expect(err).To(BeNil())
+		Expect(file.Close()).To(BeNil())
+	}
+
+	// follow files while running fn, then return all lines that were read
+	follow := func(tailer *Tailer, fn func()) (read []StreamLine) {
+		lines := make(chan StreamLine)
+		done := make(chan bool)
+		go func() {
+			for l := range lines {
+				read = append(read, l)
+			}
+			done <- true
+		}()
+		go func() {
+			tailer.Follow(lines)
+			close(lines)
+		}()
+		time.Sleep(10 * time.Millisecond)
+		fn()
+		time.Sleep(10 * time.Millisecond)
+		tailer.Stop()
+		<-done
+		return
+	}
+
+	It("follows appended lines but not existing content", func() {
+		write("a.log", "old\n")
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, ""), func() {
+			write("a.log", "new\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "new", file: dir + "/a.log"}}))
+	})
+
+	It("reads new files from the start", func() {
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, ""), func() {
+			write("b.log", "hi\nho\n")
+			write("b.txt", "nope\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "hi", file: dir + "/b.log"}, {line: "ho", file: dir + "/b.log"}}))
+	})
+
+	It("ignores directories", func() {
+		Expect(os.Mkdir(filepath.Join(dir, "c.log"), 0755)).To(BeNil())
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, ""), func() {
+			write("b.log", "hi\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "hi", file: dir + "/b.log"}}))
+	})
+
+	It("waits for lines to be complete", func() {
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, ""), func() {
+			write("a.log", "h")
+			time.Sleep(10 * time.Millisecond)
+			write("a.log", "i\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "hi", file: dir + "/a.log"}}))
+	})
+
+	It("starts over when the file was truncated", func() {
+		write("a.log", "old old old\n")
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, ""), func() {
+			Expect(os.Truncate(filepath.Join(dir, "a.log"), 0)).To(BeNil())
+			time.Sleep(10 * time.Millisecond)
+			write("a.log", "new\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "new", file: dir + "/a.log"}}))
+	})
+
+	It("follows renames", func() {
+		write("a.log", "")
+		read := follow(NewTailer([]Input{{File: dir + "/a.log"}}, ""), func() {
+			write("a.log", "before")
+			time.Sleep(10 * time.Millisecond)
+			Expect(os.Rename(filepath.Join(dir, "a.log"), filepath.Join(dir, "a.log.1"))).To(BeNil())
+			write("a.log", "after\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "before", file: dir + "/a.log"}, {line: "after", file: dir + "/a.log"}}))
+	})
+
+	It("reads what is written to the renamed file until the new file exists", func() {
+		write("a.log", "")
+		read := follow(NewTailer([]Input{{File: dir + "/a.log"}}, ""), func() {
+			Expect(os.Rename(filepath.Join(dir, "a.log"), filepath.Join(dir, "a.log.1"))).To(BeNil())
+			time.Sleep(10 * time.Millisecond)
+			write("a.log.1", "late-write-to-old-file\n")
+			time.Sleep(10 * time.Millisecond)
+			write("a.log", "new\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "late-write-to-old-file", file: dir + "/a.log"}, {line: "new", file: dir + "/a.log"}}))
+	})
+
+	It("keeps reading the renamed file while there is no new file", func() {
+		write("a.log", "")
+		read := follow(NewTailer([]Input{{File: dir + "/a.log"}}, ""), func() {
+			Expect(os.Rename(filepath.Join(dir, "a.log"), filepath.Join(dir, "a.log.1"))).To(BeNil())
+			time.Sleep(10 * time.Millisecond)
+			write("a.log.1", "late\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "late", file: dir + "/a.log"}}))
+	})
+
+	It("stops reading removed files", func() {
+		write("a.log", "")
+		read := follow(NewTailer([]Input{{File: dir + "/a.log"}}, ""), func() {
+			write("a.log", "before")
+			time.Sleep(10 * time.Millisecond)
+			Expect(os.Remove(filepath.Join(dir, "a.log"))).To(BeNil())
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "before", file: dir + "/a.log"}}))
+	})
+
+	It("does not read files again that were renamed to a path that also matches", func() {
+		write("app.log", "")
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, ""), func() {
+			write("app.log", "before\n")
+			time.Sleep(10 * time.Millisecond)
+			Expect(os.Rename(filepath.Join(dir, "app.log"), filepath.Join(dir, "app-1.log"))).To(BeNil())
+			time.Sleep(10 * time.Millisecond)
+			write("app-1.log", "late\n")
+			write("app.log", "after\n")
+			time.Sleep(10 * time.Millisecond)
+		})
+		Expect(read).To(ConsistOf(
+			StreamLine{line: "before", file: dir + "/app.log"},
+			Or(Equal(StreamLine{line: "late", file: dir + "/app.log"}), Equal(StreamLine{line: "late", file: dir + "/app-1.log"})),
+			StreamLine{line: "after", file: dir + "/app.log"},
+		))
+	})
+
+	It("decodes files", func() {
+		read := follow(NewTailer([]Input{{File: dir + "/*.log", Format: "cri"}}, ""), func() {
+			write("a.log", "2024-01-01T00:00:00Z stdout P h\n2024-01-01T00:00:00Z stdout F i\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "hi", file: dir + "/a.log", time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}}))
+	})
+
+	It("resumes from persisted positions", func() {
+		positions := filepath.Join(dir, "positions.json")
+		write("a.log", "old\n")
+		follow(NewTailer([]Input{{File: dir + "/*.log"}}, positions), func() {
+			write("a.log", "first\n")
+		})
+		write("a.log", "while stopped\n")
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, positions), func() {
+			write("a.log", "second\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "while stopped", file: dir + "/a.log"}, {line: "second", file: dir + "/a.log"}}))
+	})
+
+	It("does not write positions when nothing changed", func() {
+		positions := filepath.Join(dir, "positions.json")
+		follow(NewTailer([]Input{{File: dir + "/*.log"}}, positions), func() {
+			write("a.log", "first\n")
+			time.Sleep(10 * time.Millisecond)
+			Expect(os.Remove(positions)).To(BeNil())
+			time.Sleep(10 * time.Millisecond)
+		})
+		Expect(positions).ToNot(BeAnExistingFile())
+	})
+
+	It("ignores corrupted positions", func() {
+		positions := filepath.Join(dir, "positions.json")
+		Expect(os.WriteFile(positions, []byte("{{"), 0644)).To(BeNil())
+		write("a.log", "old\n")
+		read := follow(NewTailer([]Input{{File: dir + "/*.log"}}, positions), func() {
+			write("a.log", "new\n")
+		})
+		Expect(read).To(Equal([]StreamLine{{line: "new", file: dir + "/a.log"}}))
+	})
+
+	It("adds the file to the log", func() {
+		withConfig("", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			output := captureStdout(func() {
+				config.Output.Start()
+				processLine(StreamLine{line: "hi", file: "a.log"}, config)
+				config.Output.Stop()
+			})
+			Expect(output).To(Equal("{\"message\":\"hi\",\"file\":\"a.log\"}\n"))
+		})
+	})
+
+	It("follows files from config while reading stdin", func() {
+		write("a.log", "")
+		withConfig("---\ninputs:\n- file: "+dir+"/*.log", func() {
+			var wait func()
+			withStdin("hi\n", true, func() {
+				var output func() string
+				output, wait = runInBackground()
+				Eventually(output).Should(ContainSubstring(`{"message":"hi"}`))
+				write("a.log", "from file\n")
+				Eventually(output).Should(ContainSubstring(`{"message":"from file","file":"` + dir + `/a.log"}`))
+			})
+			wait()
+		})
+	})
+
+	It("follows files from flags while running a command", func() {
+		write("a.log", "")
+		done := filepath.Join(dir, "done")
+		withConfig("", func() {
+			output, wait := runInBackground("-file", dir+"/*.log", "--", "sh", "-c", "echo hi; while [ ! -e "+done+" ]; do sleep 0.01; done")
+			Eventually(output).Should(ContainSubstring(`{"message":"hi"}`))
+			write("a.log", "from file\n")
+			Eventually(output).Should(ContainSubstring(`{"message":"from file","file":"` + dir + `/a.log"}`))
+			write("done", "")
+			wait()
+		})
+	})
+})
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	templateParam          = "<*>"
+	drainPrefixTokens      = 1   // how deep the tree goes before comparing lines to templates, the second token is often already variable
+	drainMaxChildren       = 100 // tokens per tree node before they all share the <*> node
+	defaultTemplatesToShow = 20
+)
+
+// clusters lines that only matched the catch-all (or nothing) into templates like `connecting to <*> failed`
+// so the most frequent unknown lines can be turned into patterns
+type Templates struct {
+	Key          string  // field to put the template id in, default template_id
+	Similarity   float64 // share of tokens that need to be equal to join a template, default 0.4
+	MaxTemplates int     `yaml:"maxTemplates"` // stop learning new templates after this many, default 1000
+	mutex        sync.Mutex
+	drain        *drain
+}
+
+func (t *Templates) setup() error {
+	if t.Key == "" {
+		t.Key = "template_id"
+	}
+	if t.Similarity == 0 {
+		t.Similarity = 0.4
+	}
+	if t.Similarity < 0 || t.Similarity > 1 {
+		return fmt.Errorf("templates.similarity must be between 0.0 - 1.0 but was %f", t.Similarity)
+	}
+	if t.MaxTemplates == 0 {
+		t.MaxTemplates = 1000
+	}
+	if t.MaxTemplates < 0 {
+		return fmt.Errorf("templates.maxTemplates must be positive but was %d", t.MaxTemplates)
+	}
+	t.drain = newDrain(t.Similarity, t.MaxTemplates)
+	return nil
+}
+
+// id of the template the message belongs to, empty when no new templates can be learned
+func (t *Templates) add(message string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if template := t.drain.add(message); template != nil {
+		return template.id
+	}
+	return ""
+}
+
+// most frequent templates as json, GET /debug/templates?limit=20
+func (t *Templates) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = defaultTemplatesToShow
+	}
+
+	type shown struct {
+		Id       string `json:"id"`
+		Template string `json:"template"`
+		Count    int    `json:"count"`
+		Sample   string `json:"sample"`
+	}
+	t.mutex.Lock()
+	templates := []shown{}
+	for _, template := range t.drain.top(limit) {
+		templates = append(templates, shown{Id: template.id, Template: template.String(), Count: template.count, Sample: template.sample})
+	}
+	t.mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false) // keep <*> readable
+	_ = encoder.Encode(templates)
+}
+
+// online log clustering, not safe for concurrent use
+// https://jiemingzhu.github.io/pub/pjhe_icws2017.pdf
+type drain struct {
+	similarity   float64
+	maxTemplates int
+	root         map[int]*drainNode // by token count, since lines of the same template have the same length
+	templates    []*template
+}
+
+type drainNode struct {
+	children  map[string]*drainNode
+	templates []*template
+}
+
+type template struct {
+	id     string // hash of the tokens, so the same template has the same id after restarts and on other hosts
+	tokens []string
+	count  int
+	sample string // first line
+}
+
+func (t *template) String() string {
+	return strings.Join(t.tokens, " ")
+}
+
+func newDrain(similarity float64, maxTemplates int) *drain {
+	return &drain{similarity: similarity, maxTemplates: maxTemplates, root: map[int]*drainNode{}}
+}
+
+// template the line belongs to, creating or generalizing it, nil when there are too many templates
+func (d *drain) add(line string) *template {
+	tokens := drainTokens(line)
+	leaf := d.leaf(tokens)
+
+	if best := d.mostSimilar(leaf, tokens); best != nil {
+		generalized := false
+		for i, token := range tokens {
+			if best.tokens[i] != token {
+				best.tokens[i] = templateParam
+				generalized = true
+			}
+		}
+		if generalized {
+			best.id = templateId(best.tokens)
+		}
+		best.count++
+		return best
+	}
+
+	if len(d.templates) >= d.maxTemplates {
+		return nil
+	}
+	created := &template{id: templateId(tokens), tokens: tokens, count: 1, sample: line}
+	leaf.templates = append(leaf.templates, created)
+	d.templates = append(d.templates, created)
+	return created
+}
+
+func templateId(tokens []string) string {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(strings.Join(tokens, " ")))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// walk the tree by length and first tokens, variable tokens share a node
+func (d *drain) leaf(tokens []string) *drainNode {
+	node, found := d.root[len(tokens)]
+	if !found {
+		node = &drainNode{children: map[string]*drainNode{}}
+		d.root[len(tokens)] = node
+	}
+	for i := 0; i < drainPrefixTokens && i < len(tokens); i++ {
+		token := tokens[i]
+		if _, found := node.children[token]; !found && len(node.children) >= drainMaxChildren {
+			token = templateParam
+		}
+		child, found := node.children[token]
+		if !found {
+			child = &drainNode{children: map[string]*drainNode{}}
+			node.children[token] = child
+		}
+		node = child
+	}
+	return node
+}
+
+// template with the most equal tokens, preferring the more general one on ties
+func (d *drain) mostSimilar(leaf *drainNode, tokens []string) (best *template) {
+	bestSimilarity := -1.0
+	bestParams := -1
+	for _, template := range leaf.templates {
+		equal := 0
+		params := 0
+		for i, token := range template.tokens {
+			if token == templateParam {
+				params++
+			} else if token == tokens[i] {
+				equal++
+			}
+		}
+		similarity := 1.0 // empty lines
+		if len(tokens) != 0 {
+			similarity = float64(equal) / float64(len(tokens))
+		}
+		if similarity > bestSimilarity || (similarity == bestSimilarity && params > bestParams) {
+			best, bestSimilarity, bestParams = template, similarity, params
+		}
+	}
+	if best == nil || bestSimilarity < d.similarity {
+		return nil
+	}
+	return best
+}
+
+// most frequent first
+func (d *drain) top(limit int) []*template {
+	sorted := make([]*template, len(d.templates))
+	copy(sorted, d.templates)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].count > sorted[j].count })
+	if len(sorted) > limit {
+		sorted = sorted[:limit]
+	}
+	return sorted
+}
+
+// split on whitespace, tokens with digits are almost always variable (ids, ips, durations, counts)
+func drainTokens(line string) []string {
+	tokens := strings.Fields(line)
+	for i, token := range tokens {
+		if strings.IndexFunc(token, unicode.IsDigit) != -1 {
+			tokens[i] = templateParam
+		}
+	}
+	return tokens
+}
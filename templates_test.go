@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("templates", func() {
+	templates := func(d *drain) (shown []string) {
+		for _, template := range d.top(100) {
+			shown = append(shown, fmt.Sprintf("%d %s", template.count, template))
+		}
+		return
+	}
+
+	It("groups lines that only differ in variable tokens", func() {
+		d := newDrain(0.4, 1000)
+		for _, line := range []string{
+			"connecting to db-1 failed after 3 retries",
+			"connecting to db-2 failed after 5 retries",
+			"user alice logged in",
+			"connecting to cache failed after 1 retries",
+			"user bob logged in",
+			"shutting down",
+		} {
+			d.add(line)
+		}
+		Expect(templates(d)).To(Equal([]string{
+			"3 connecting to <*> failed after <*> retries",
+			"2 user <*> logged in",
+			"1 shutting down",
+		}))
+		Expect(d.top(1)[0].sample).To(Equal("connecting to db-1 failed after 3 retries"))
+	})
+
+	It("keeps lines that are not similar enough apart", func() {
+		d := newDrain(0.9, 1000)
+		d.add("user alice logged in")
+		d.add("user bob logged in")
+		Expect(templates(d)).To(Equal([]string{"1 user alice logged in", "1 user bob logged in"}))
+	})
+
+	It("uses the same id for the same template no matter which lines built it", func() {
+		d := newDrain(0.4, 1000)
+		specific := d.add("user alice logged in").id
+		Expect(specific).To(MatchRegexp(`^[0-9a-f]{8}$`))
+		id := d.add("user bob logged in").id
+		Expect(id).ToNot(Equal(specific)) // more general now
+
+		restarted := newDrain(0.4, 1000)
+		restarted.add("user carol logged in")
+		Expect(restarted.add("user dave logged in").id).To(Equal(id))
+		Expect(restarted.add("user erin logged in").id).To(Equal(id))
+	})
+
+	It("prefers the more general template", func() {
+		d := newDrain(0.4, 1000)
+		d.add("a b c d")
+		d.add("a b x y")
+		d.add("a b x z")
+		Expect(templates(d)).To(Equal([]string{"3 a b <*> <*>"}))
+	})
+
+	It("treats tokens with digits as variable", func() {
+		Expect(drainTokens(" took  12ms for job-7 ")).To(Equal([]string{"took", templateParam, "for", templateParam}))
+	})
+
+	It("groups empty lines", func() {
+		d := newDrain(0.4, 1000)
+		d.add("")
+		d.add(" ")
+		Expect(templates(d)).To(Equal([]string{"2 "}))
+	})
+
+	It("stops learning when there are too many templates", func() {
+		t := &Templates{MaxTemplates: 1}
+		Expect(t.setup()).To(BeNil())
+		Expect(t.add("a")).ToNot(BeEmpty())
+		Expect(t.add("b")).To(BeEmpty())
+		Expect(t.add("a")).ToNot(BeEmpty())
+	})
+
+	It("shares a node when a token has too many values", func() {
+		d := newDrain(0.4, 1000)
+		for i := 0; i < drainMaxChildren+5; i++ {
+			d.add(fmt.Sprintf("job%c%c done", 'a'+i/26, 'a'+i%26))
+		}
+		Expect(len(d.root[2].children)).To(Equal(drainMaxChildren + 1))
+		Expect(d.root[2].children[templateParam].templates[0].count).To(Equal(5))
+	})
+
+	It("adds template ids to lines that no pattern knows", func() {
+		withConfig("---\nprometheus: {port: "+randomPort()+"}\ntemplates: {key: tid}\npatterns:\n- regex: known\n- regex: ''\n  add: {unknown: 'true'}", func() {
+			Expect(parse("known\nuser alice logged in\nuser bob logged in")).To(Equal(
+				"{\"message\":\"known\"}\n" +
+					"{\"message\":\"user alice logged in\",\"unknown\":\"true\",\"tid\":\"" + templateId([]string{"user", "alice", "logged", "in"}) + "\"}\n" +
+					"{\"message\":\"user bob logged in\",\"unknown\":\"true\",\"tid\":\"" + templateId([]string{"user", templateParam, "logged", "in"}) + "\"}",
+			))
+		})
+	})
+
+	It("does not add template ids to metric labels", func() {
+		withConfig("---\nprometheus: {}\ntemplates: {}", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			processed := prepareLine(StreamLine{line: "hi"}, config)
+			Expect(processed.serialized).To(ContainSubstring("template_id"))
+			Expect(processed.labels).To(BeEmpty())
+		})
+	})
+
+	It("serves the most frequent templates", func() {
+		port := randomPort()
+		t := &Templates{}
+		Expect(t.setup()).To(BeNil())
+		t.add("user alice logged in")
+		t.add("user bob logged in")
+		t.add("shutting down")
+		p := &Prometheus{Port: port, Templates: t}
+		p.Start()
+		defer p.Stop()
+		time.Sleep(10 * time.Millisecond)
+
+		id := t.drain.top(1)[0].id
+		Expect(request("http://0.0.0.0:" + port + "/debug/templates?limit=1")).To(Equal(
+			"[{\"id\":\"" + id + "\",\"template\":\"user <*> logged in\",\"count\":2,\"sample\":\"user alice logged in\"}]\n",
+		))
+		Expect(request("http://0.0.0.0:" + port + "/debug/templates")).To(ContainSubstring("shutting down"))
+	})
+
+	It("fails on invalid similarity", func() {
+		withConfig("---\ntemplates: {similarity: 2}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("templates.similarity must be between 0.0 - 1.0 but was 2.000000"))
+		})
+	})
+
+	It("fails without prometheus", func() {
+		withConfig("---\ntemplates: {}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("templates needs prometheus to be set"))
+		})
+	})
+
+	It("fails on invalid maxTemplates", func() {
+		withConfig("---\ntemplates: {maxTemplates: -1}", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("templates.maxTemplates must be positive but was -1"))
+		})
+	})
+})
@@ -7,9 +7,14 @@ import (
 	"os/exec"
 	"os/signal"
 	"regexp"
+	"strings"
 	"syscall"
+	"time"
 )
 
+// {2006.01.02} in templates is replaced with the time in go time format
+var timeTemplateRegex = regexp.MustCompile(`\{([^}]+)\}`)
+
 // https://www.golangprograms.com/remove-duplicate-values-from-slice.html
 func unique(input []string) []string {
 	keys := make(map[string]bool)
@@ -34,6 +39,15 @@ func removeElement(haystack []string, needle string) []string {
 	return clean
 }
 
+func contains(haystack []string, needle string) bool {
+	for _, item := range haystack {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
 // split an array of strings when a given delimiter is found
 func splitArrayOn(arr []string, delimiter string) ([]string, []string) {
 	for i, item := range arr {
@@ -53,6 +67,18 @@ func keys(mymap map[string]string) []string {
 	return keys
 }
 
+// flag that can be given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func check(e error) {
 	if e != nil {
 		panic(e) // untested section
@@ -83,20 +109,31 @@ func isPipingToStdin() bool {
 	return (stat.Mode() & os.ModeCharDevice) == 0
 }
 
+func isTerminal(file *os.File) bool {
+	stat, err := file.Stat()
+	return err == nil && (stat.Mode()&os.ModeCharDevice) != 0
+}
+
+func formatTimeTemplate(template string, t time.Time) string {
+	return timeTemplateRegex.ReplaceAllStringFunc(template, func(layout string) string {
+		return t.UTC().Format(layout[1 : len(layout)-1])
+	})
+}
+
 // executeCommand executes a shell command and returns a readers from stdout and stderr + exit code channel
-func executeCommand(command []string) ([]io.Reader, chan (int), error) {
+func executeCommand(command []string) ([]io.Reader, chan (int), int, error) {
 	cmd := exec.Command(command[0], command[1:]...)
 	exit := make(chan int)
 
 	// create pipes for stdout and stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil { // untested section
-		return nil, nil, err
+		return nil, nil, 0, err
 	}
 	stderr, err := cmd.StderrPipe()
 
 	if err != nil { // untested section
-		return nil, nil, err
+		return nil, nil, 0, err
 	}
 	streams := []io.Reader{stdout, stderr}
 
@@ -104,26 +141,26 @@ func executeCommand(command []string) ([]io.Reader, chan (int), error) {
 	err = cmd.Start()
 	if err != nil {
 		// untested section
-		return nil, nil, err
+		return nil, nil, 0, err
 	}
 
 	// Pass on any signal, so the logrecycler behaves like the command it wraps
 	signalChannel := make(chan os.Signal, 1)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
 	go func() {
-		s, open := <-signalChannel
-		if open {
-			// untested section
+		for s := range signalChannel {
 			_ = cmd.Process.Signal(s)
 		}
 	}()
 
 	// Wait for the command to finish and store the exit code
+	// not using cmd.Wait since it closes the pipes before we are done reading them
 	go func() {
-		_ = cmd.Wait()
-		close(signalChannel) // make sure exiting the program does not re-signal ourselves
-		exit <- cmd.ProcessState.ExitCode()
+		state, _ := cmd.Process.Wait()
+		signal.Stop(signalChannel) // a signal sent to a closed channel would panic
+		close(signalChannel)       // make sure exiting the program does not re-signal ourselves
+		exit <- state.ExitCode()
 	}()
 
-	return streams, exit, nil
+	return streams, exit, cmd.Process.Pid, nil
 }
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"sync"
+)
+
+// lines that can be processed at the same time per worker, limits memory when one line is slow
+const linesPerWorker = 64
+
+type sequencedLine struct {
+	sequence  uint64
+	line      StreamLine
+	processed *processedLine
+}
+
+// prepare lines on multiple cores, but emit them in the order they were read
+// so output order per stream stays the same and metrics/sinks are only updated from one goroutine
+func processParallel(lines <-chan StreamLine, config *Config) {
+	inFlight := make(chan struct{}, config.Workers*linesPerWorker)
+	jobs := make(chan sequencedLine, config.Workers)
+	results := make(chan sequencedLine, config.Workers)
+
+	var workers sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				job.processed = prepareLine(job.line, config)
+				results <- job
+			}
+		}()
+	}
+
+	go func() {
+		var sequence uint64
+		for line := range lines {
+			inFlight <- struct{}{}
+			jobs <- sequencedLine{sequence: sequence, line: line}
+			sequence++
+		}
+		close(jobs)
+		workers.Wait()
+		close(results)
+	}()
+
+	// hold back lines that finished before earlier lines
+	pending := map[uint64]*processedLine{}
+	var next uint64
+	for result := range results {
+		pending[result.sequence] = result.processed
+		for {
+			processed, found := pending[next]
+			if !found {
+				break
+			}
+			delete(pending, next)
+			next++
+			if processed != nil {
+				emitLine(processed, config)
+				processed.release()
+			}
+			<-inFlight
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// remembers everything it was given
+type recordingSink struct {
+	entries []*Entry
+	dropped atomic.Uint64
+}
+
+func (r *recordingSink) Name() string            { return "recording" }
+func (r *recordingSink) Start()                  {}
+func (r *recordingSink) Stop()                   {}
+func (r *recordingSink) Write(entry *Entry)      { r.entries = append(r.entries, entry) }
+func (r *recordingSink) Dropped() *atomic.Uint64 { return &r.dropped }
+
+var _ = Describe("workers", func() {
+	// lines 0..count-1 with every 10th discarded
+	processAll := func(count int, workers int) (stdout string, sink *recordingSink) {
+		withConfig("---\nworkers: "+strconv.Itoa(workers)+"\npatterns:\n- regex: '0$'\n  discard: true\n- regex: (?P<n>\\d+)", func() {
+			config, err := NewConfig("logrecycler.yaml")
+			Expect(err).To(BeNil())
+			sink = &recordingSink{}
+			config.Output.sinks = []Sink{sink}
+
+			lines := make(chan StreamLine)
+			go func() {
+				for i := 0; i < count; i++ {
+					lines <- StreamLine{index: i % 2, line: strconv.Itoa(i)}
+				}
+				close(lines)
+			}()
+
+			stdout = captureStdout(func() {
+				captureStderr(func() {
+					config.Output.Start()
+					processParallel(lines, config)
+					config.Output.Stop()
+				})
+			})
+		})
+		return
+	}
+
+	It("keeps the order lines were read in", func() {
+		stdout, sink := processAll(2000, 8)
+
+		expected := []string{}
+		for i := 0; i < 2000; i += 2 {
+			if i%10 != 0 {
+				expected = append(expected, fmt.Sprintf(`{"message":"%d","n":"%d"}`, i, i))
+			}
+		}
+		Expect(strings.Split(strings.TrimSpace(stdout), "\n")).To(Equal(expected))
+
+		Expect(len(sink.entries)).To(Equal(1800))
+		for i, entry := range sink.entries {
+			n := i + i/9 + 1 // skipping the discarded
+			Expect(entry.labels).To(Equal(map[string]string{"n": strconv.Itoa(n)}))
+		}
+	})
+
+	It("processes with workers when configured", func() {
+		withConfig("---\nworkers: 4\npatterns:\n- regex: (?P<n>\\d+)", func() {
+			Expect(runWithCommand("sh", "-c", "echo 1; echo 2; echo 3")).To(Equal(
+				"{\"message\":\"1\",\"n\":\"1\"}\n{\"message\":\"2\",\"n\":\"2\"}\n{\"message\":\"3\",\"n\":\"3\"}",
+			))
+		})
+	})
+
+	It("fails on negative workers", func() {
+		withConfig("---\nworkers: -1", func() {
+			_, err := NewConfig("logrecycler.yaml")
+			Expect(err.Error()).To(Equal("workers must be positive but was -1"))
+		})
+	})
+})
+
+// go test -run none -bench Workers -benchmem
+// shows throughput scaling with more workers and the cpu/memory overhead per line
+func BenchmarkWorkers(b *testing.B) {
+	dir, err := os.MkdirTemp("", "logrecycler")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a realistic config with many patterns, half the lines contain an "error N" that gets past the prefilter
+	config := "levelKey: level\ntimestampKey: ts\npatterns:\n"
+	for i := 0; i < 30; i++ {
+		config += fmt.Sprintf("- regex: 'error %d.*(?P<host>\\S+):(?P<port>\\d+)'\n  level: ERROR\n  add: {pattern: p%d}\n", i, i)
+	}
+	config += "- regex: ''\n  add: {pattern: unknown}\n"
+	path := filepath.Join(dir, "logrecycler.yaml")
+	if err = os.WriteFile(path, []byte(config), 0644); err != nil {
+		b.Fatal(err)
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer devNull.Close()
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(strconv.Itoa(workers), func(b *testing.B) {
+			c, err := NewConfig(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			c.Workers = workers
+			c.Output.stdout.start(devNull, c.Output.BufferSize)
+			c.Output.stderr.start(devNull, c.Output.BufferSize)
+
+			lines := make(chan StreamLine, 1000)
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if i%2 == 0 {
+						lines <- StreamLine{line: fmt.Sprintf("I0102 03:04:05.678 error %d in request %d from 10.0.0.1:443", i%30, i)}
+					} else {
+						lines <- StreamLine{line: "I0102 03:04:05.678 request " + strconv.Itoa(i) + " took 12ms from 10.0.0.1:443"}
+					}
+				}
+				close(lines)
+			}()
+
+			b.ResetTimer()
+			if workers > 1 {
+				processParallel(lines, c)
+			} else {
+				for line := range lines {
+					processLine(line, c)
+				}
+			}
+			c.Output.Stop()
+		})
+	}
+}